@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/auth"
+	"github.com/depot/depot-go/logger"
+	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
+)
+
+const DefaultBaseURL = "https://api.depot.dev"
+
+// Client holds the configuration used to talk to the Depot API.
+// Several clients with different configurations can be used in the same process.
+type Client struct {
+	baseURL        string
+	httpClient     *http.Client
+	connectOptions []connect.ClientOption
+	tokenSource    auth.TokenSource
+	logger         *slog.Logger
+
+	buildService cliv1connect.BuildServiceClient
+}
+
+type ClientOption func(*Client)
+
+// WithBaseURL sets the Depot API URL.  Defaults to DEPOT_API_URL or https://api.depot.dev.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for API requests.  Defaults to http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithConnectOptions appends connect client options to every API client.
+func WithConnectOptions(opts ...connect.ClientOption) ClientOption {
+	return func(c *Client) {
+		c.connectOptions = append(c.connectOptions, opts...)
+	}
+}
+
+// WithTokenSource sets where the API token comes from when a request is not
+// already authenticated.  Defaults to auth.ResolvingTokenSource.
+func WithTokenSource(tokenSource auth.TokenSource) ClientOption {
+	return func(c *Client) {
+		c.tokenSource = tokenSource
+	}
+}
+
+// WithToken uses a fixed API token when a request is not already authenticated.
+func WithToken(token string) ClientOption {
+	return WithTokenSource(auth.StaticTokenSource(token))
+}
+
+// WithLogger sets the logger used by this client.  Defaults to the Depot logger.
+func WithLogger(l *slog.Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		baseURL:     os.Getenv("DEPOT_API_URL"),
+		httpClient:  http.DefaultClient,
+		tokenSource: auth.ResolvingTokenSource(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+
+	connectOptions := append([]connect.ClientOption{WithUserAgent(), WithTokenAuthentication(c.tokenSource)}, c.connectOptions...)
+	c.buildService = cliv1connect.NewBuildServiceClient(c.httpClient, c.baseURL, connectOptions...)
+	return c
+}
+
+// BaseURL returns the Depot API URL of this client.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// BuildService returns the BuildService API client.
+func (c *Client) BuildService() cliv1connect.BuildServiceClient {
+	return c.buildService
+}
+
+// Token returns token if it is set, otherwise the token from the client's token source.
+func (c *Client) Token(ctx context.Context, token string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	return c.tokenSource.Token(ctx)
+}
+
+// Logger returns the client's logger, or the Depot logger if none was set.
+func (c *Client) Logger() *slog.Logger {
+	if c.logger != nil {
+		return c.logger
+	}
+	return logger.GetLogger()
+}
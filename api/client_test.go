@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/auth"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
+)
+
+type createBuildHandler struct {
+	cliv1connect.UnimplementedBuildServiceHandler
+	userAgent     string
+	authorization string
+}
+
+func (h *createBuildHandler) CreateBuild(ctx context.Context, req *connect.Request[cliv1.CreateBuildRequest]) (*connect.Response[cliv1.CreateBuildResponse], error) {
+	h.userAgent = req.Header().Get("User-Agent")
+	h.authorization = req.Header().Get("Authorization")
+	return connect.NewResponse(&cliv1.CreateBuildResponse{BuildId: "build-" + req.Msg.ProjectId}), nil
+}
+
+func TestClient(t *testing.T) {
+	handler := &createBuildHandler{}
+	path, h := cliv1connect.NewBuildServiceHandler(handler)
+	mux := http.NewServeMux()
+	mux.Handle(path, h)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("DEPOT_API_URL", "http://127.0.0.1:0")
+	client := NewClient(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithToken("secret"))
+	if client.BaseURL() != server.URL {
+		t.Fatalf("BaseURL() = %q, want %q", client.BaseURL(), server.URL)
+	}
+
+	ctx := context.Background()
+	token, err := client.Token(ctx, "")
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token != "secret" {
+		t.Fatalf("Token() = %q, want %q", token, "secret")
+	}
+
+	req := WithAuthentication(connect.NewRequest(&cliv1.CreateBuildRequest{ProjectId: "abc"}), token)
+	res, err := client.BuildService().CreateBuild(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateBuild() error = %v", err)
+	}
+	if res.Msg.BuildId != "build-abc" {
+		t.Errorf("BuildId = %q, want %q", res.Msg.BuildId, "build-abc")
+	}
+	if handler.authorization != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", handler.authorization, "Bearer secret")
+	}
+	if !strings.HasPrefix(handler.userAgent, "depot-go/") {
+		t.Errorf("User-Agent = %q, want depot-go prefix", handler.userAgent)
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	handler := &createBuildHandler{}
+	path, h := cliv1connect.NewBuildServiceHandler(handler)
+	mux := http.NewServeMux()
+	mux.Handle(path, h)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	req := func() *connect.Request[cliv1.CreateBuildRequest] {
+		return connect.NewRequest(&cliv1.CreateBuildRequest{ProjectId: "abc"})
+	}
+
+	client := NewClient(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithToken("secret"))
+	if _, err := client.BuildService().CreateBuild(ctx, req()); err != nil {
+		t.Fatalf("CreateBuild() error = %v", err)
+	}
+	if handler.authorization != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the token source's token", handler.authorization)
+	}
+
+	if _, err := client.BuildService().CreateBuild(ctx, WithAuthentication(req(), "build-token")); err != nil {
+		t.Fatalf("CreateBuild() error = %v", err)
+	}
+	if handler.authorization != "Bearer build-token" {
+		t.Errorf("Authorization = %q, want the explicit token", handler.authorization)
+	}
+
+	client = NewClient(WithBaseURL(server.URL), WithHTTPClient(server.Client()), WithToken(""))
+	if _, err := client.BuildService().CreateBuild(ctx, req()); !errors.Is(err, auth.ErrNoTokenFound) {
+		t.Errorf("CreateBuild() error = %v, want ErrNoTokenFound", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"connectrpc.com/connect"
+	"github.com/depot/depot-go/auth"
 	"github.com/depot/depot-go/internal/useragent"
 )
 
@@ -33,3 +34,37 @@ func (i *agentInterceptor) WrapStreamingClient(next connect.StreamingClientFunc)
 func (i *agentInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
 	return next
 }
+
+// WithTokenAuthentication authenticates every unary request that does not
+// already carry an Authorization header with a token from tokenSource.
+func WithTokenAuthentication(tokenSource auth.TokenSource) connect.ClientOption {
+	return connect.WithInterceptors(&authInterceptor{tokenSource})
+}
+
+type authInterceptor struct {
+	tokenSource auth.TokenSource
+}
+
+func (i *authInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Header().Get("Authorization") == "" {
+			token, err := i.tokenSource.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+			req.Header().Set("Authorization", "Bearer "+token)
+		}
+		return next(ctx, req)
+	}
+}
+
+// WrapStreamingClient leaves streams unauthenticated: the Depot services used
+// by this module have no streaming RPCs, and a stream cannot return the error
+// of the token source.
+func (i *authInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *authInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
@@ -1,22 +1,20 @@
 package api
 
 import (
-	"net/http"
-	"os"
-
 	"connectrpc.com/connect"
 	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
 )
 
+// NewBuildClient returns a BuildService client using the default Client configuration.
 func NewBuildClient() cliv1connect.BuildServiceClient {
-	baseURL := os.Getenv("DEPOT_API_URL")
-	if baseURL == "" {
-		baseURL = "https://api.depot.dev"
-	}
-	return cliv1connect.NewBuildServiceClient(http.DefaultClient, baseURL, WithUserAgent())
+	return NewClient().BuildService()
 }
 
+// WithAuthentication authenticates req with token, e.g. a build token.  If
+// token is empty the request is authenticated by the client's token source.
 func WithAuthentication[T any](req *connect.Request[T], token string) *connect.Request[T] {
-	req.Header().Add("Authorization", "Bearer "+token)
+	if token != "" {
+		req.Header().Set("Authorization", "Bearer "+token)
+	}
 	return req
 }
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+
+	"github.com/depot/depot-go/internal/config"
+	"github.com/depot/depot-go/internal/oidc"
+	"github.com/depot/depot-go/internal/oidc/gitlab"
+	"github.com/depot/depot-go/logger"
+)
+
+// Source is a place a Depot API token can be found.  Token is called again
+// to refresh an expiring token, so sources of short-lived tokens such as OIDC
+// providers should issue a new token each time.
+type Source interface {
+	Name() string
+	// Token returns the token, or a SkipError if the source has no token,
+	// e.g. because it is not running in its CI provider.
+	Token(ctx context.Context) (string, error)
+}
+
+// SkipError is returned by a Source that has no token to offer.
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string { return "skipped: " + e.Reason }
+
+// Skip returns a SkipError with reason.
+func Skip(reason string) error {
+	return &SkipError{Reason: reason}
+}
+
+// NewSource returns a Source named name that calls fn, e.g. to read a token
+// from a secret store.
+func NewSource(name string, fn func(ctx context.Context) (string, error)) Source {
+	return funcSource{name: name, fn: fn}
+}
+
+type funcSource struct {
+	name string
+	fn   func(ctx context.Context) (string, error)
+}
+
+func (s funcSource) Name() string                              { return s.name }
+func (s funcSource) Token(ctx context.Context) (string, error) { return s.fn(ctx) }
+
+// ExplicitSource returns a Source named "explicit" for a token given by the caller.
+func ExplicitSource(token string) Source {
+	return NewSource("explicit", func(ctx context.Context) (string, error) {
+		if token == "" {
+			return "", Skip("not given")
+		}
+		return token, nil
+	})
+}
+
+// EnvSource returns a Source reading the environment variable name, e.g. DEPOT_TOKEN.
+func EnvSource(name string) Source {
+	return NewSource(name, func(ctx context.Context) (string, error) {
+		token := os.Getenv(name)
+		if token == "" {
+			return "", Skip("not set")
+		}
+		return token, nil
+	})
+}
+
+// ConfigSource returns a Source named "config" reading the token saved by `depot login`.
+func ConfigSource() Source {
+	return NewSource("config", func(ctx context.Context) (string, error) {
+		token := config.GetApiToken()
+		if token == "" {
+			return "", Skip("no token in the config file")
+		}
+		return token, nil
+	})
+}
+
+// OIDCSources returns a Source for each supported CI OIDC provider, named
+// "oidc/" and the provider, e.g. "oidc/github".
+func OIDCSources() []Source {
+	sources := make([]Source, 0, len(oidc.Providers))
+	for _, provider := range oidc.Providers {
+		sources = append(sources, oidcSource(provider))
+	}
+	return sources
+}
+
+func oidcSource(provider oidc.OIDCProvider) Source {
+	return NewSource("oidc/"+provider.Name(), func(ctx context.Context) (string, error) {
+		token, err := provider.RetrieveToken(ctx)
+		if err == nil && token == "" {
+			return "", Skip("no token available")
+		}
+		return token, err
+	})
+}
+
+// GitLabSource returns a Source named "oidc/gitlab" reading the GitLab ID
+// token from the variable named in the id_tokens of the job.  Use it in place
+// of the default "oidc/gitlab" source, which reads DEPOT_OIDC_TOKEN or the
+// variable named by DEPOT_OIDC_TOKEN_VARIABLE.
+func GitLabSource(variable string) Source {
+	return oidcSource(gitlab.NewGitLabOIDCProvider(variable))
+}
+
+// Chain tries sources in order and uses the first token found.
+type Chain struct {
+	sources []Source
+}
+
+// ChainOption configures a Chain.
+type ChainOption func(*Chain)
+
+// NewChain returns a chain of sources.
+func NewChain(sources []Source, opts ...ChainOption) *Chain {
+	c := &Chain{sources: slices.Clone(sources)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// DefaultChain returns the chain used by ResolveToken: DEPOT_TOKEN, the
+// config file, then each OIDC provider.
+func DefaultChain(opts ...ChainOption) *Chain {
+	sources := append([]Source{EnvSource("DEPOT_TOKEN"), ConfigSource()}, OIDCSources()...)
+	return NewChain(sources, opts...)
+}
+
+// AppendSource adds sources to the end of the chain.
+func AppendSource(sources ...Source) ChainOption {
+	return func(c *Chain) {
+		c.sources = append(c.sources, sources...)
+	}
+}
+
+// PrependSource adds sources to the start of the chain.
+func PrependSource(sources ...Source) ChainOption {
+	return func(c *Chain) {
+		c.sources = append(slices.Clone(sources), c.sources...)
+	}
+}
+
+// RemoveSource removes the sources named names, e.g. "oidc/actions-public".
+func RemoveSource(names ...string) ChainOption {
+	return func(c *Chain) {
+		c.sources = slices.DeleteFunc(c.sources, func(s Source) bool {
+			return slices.Contains(names, s.Name())
+		})
+	}
+}
+
+// OrderSources moves the sources named names to the start of the chain in
+// that order.  The other sources keep their order after them.
+func OrderSources(names ...string) ChainOption {
+	return func(c *Chain) {
+		rank := func(s Source) int {
+			if i := slices.Index(names, s.Name()); i >= 0 {
+				return i
+			}
+			return len(names)
+		}
+		slices.SortStableFunc(c.sources, func(a, b Source) int { return rank(a) - rank(b) })
+	}
+}
+
+// Sources returns the names of the sources in order.
+func (c *Chain) Sources() []string {
+	names := make([]string, 0, len(c.sources))
+	for _, s := range c.sources {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+// Resolve returns the token of the first source that has one and reports
+// the sources tried.  If none has a token the error is a *ResolutionError.
+func (c *Chain) Resolve(ctx context.Context) (string, Resolution, error) {
+	token, _, resolution, err := c.resolve(ctx)
+	return token, resolution, err
+}
+
+// resolve also returns the source of the token to refresh it from.
+func (c *Chain) resolve(ctx context.Context) (string, Source, Resolution, error) {
+	var resolution Resolution
+	for _, s := range c.sources {
+		logger.DebugContext(ctx, "Trying token source", "source", s.Name())
+
+		token, err := s.Token(ctx)
+		attempt := Attempt{Source: s.Name(), Found: err == nil && token != ""}
+		var skip *SkipError
+		switch {
+		case errors.As(err, &skip):
+			attempt.Skipped = skip.Reason
+		case err != nil:
+			attempt.Err = err
+			logger.DebugContext(ctx, "Token source failed", "source", s.Name(), "error", err)
+		case token == "":
+			attempt.Skipped = "no token"
+		}
+		resolution.Attempts = append(resolution.Attempts, attempt)
+
+		if attempt.Found {
+			return token, s, resolution, nil
+		}
+	}
+
+	return "", nil, resolution, &ResolutionError{Resolution: resolution}
+}
+
+// TokenSource returns a TokenSource that resolves the token from the chain
+// on first use.  Tokens are cached until shortly before they expire and then
+// retrieved again from the source that returned them.
+func (c *Chain) TokenSource() TokenSource {
+	return CachingTokenSource(&resolvingTokenSource{chain: c})
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("DEPOT_TOKEN", "env-token")
+	setProviders(t, fakeProvider{name: "github"}, fakeProvider{name: "actions-public", token: "public-token"})
+
+	vault := NewSource("vault", func(ctx context.Context) (string, error) {
+		return "vault-token", nil
+	})
+
+	tests := []struct {
+		name        string
+		opts        []ChainOption
+		wantSources []string
+		wantToken   string
+	}{
+		{
+			name:        "default",
+			wantSources: []string{"DEPOT_TOKEN", "config", "oidc/github", "oidc/actions-public"},
+			wantToken:   "env-token",
+		},
+		{
+			name:        "append",
+			opts:        []ChainOption{AppendSource(vault)},
+			wantSources: []string{"DEPOT_TOKEN", "config", "oidc/github", "oidc/actions-public", "vault"},
+			wantToken:   "env-token",
+		},
+		{
+			name:        "prepend",
+			opts:        []ChainOption{PrependSource(vault)},
+			wantSources: []string{"vault", "DEPOT_TOKEN", "config", "oidc/github", "oidc/actions-public"},
+			wantToken:   "vault-token",
+		},
+		{
+			name:        "remove",
+			opts:        []ChainOption{RemoveSource("DEPOT_TOKEN", "config")},
+			wantSources: []string{"oidc/github", "oidc/actions-public"},
+			wantToken:   "public-token",
+		},
+		{
+			name:        "order",
+			opts:        []ChainOption{OrderSources("oidc/actions-public", "config")},
+			wantSources: []string{"oidc/actions-public", "config", "DEPOT_TOKEN", "oidc/github"},
+			wantToken:   "public-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := DefaultChain(tt.opts...)
+			if got := chain.Sources(); !slices.Equal(got, tt.wantSources) {
+				t.Errorf("Sources() = %v, want %v", got, tt.wantSources)
+			}
+			token, _, err := chain.Resolve(ctx)
+			if err != nil || token != tt.wantToken {
+				t.Errorf("Resolve() = %q, %v, want %q", token, err, tt.wantToken)
+			}
+		})
+	}
+
+	// An expired token is refreshed from the source that returned it.
+	tokens := []string{jwt(time.Now()), "vault-token-2"}
+	refreshing := NewSource("vault", func(ctx context.Context) (string, error) {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token, nil
+	})
+	ts := NewChain([]Source{refreshing, EnvSource("DEPOT_TOKEN")}).TokenSource()
+	if _, err := ts.Token(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ts.Token(ctx); err != nil || token != "vault-token-2" {
+		t.Errorf("Token() = %q, %v, want the refreshed token", token, err)
+	}
+}
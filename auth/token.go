@@ -3,58 +3,100 @@ package auth
 import (
 	"context"
 	"errors"
-	"os"
-
-	"github.com/depot/depot-go/internal/config"
-	"github.com/depot/depot-go/internal/oidc"
-	"github.com/depot/depot-go/logger"
+	"fmt"
+	"strings"
 )
 
 var ErrNoTokenFound = errors.New("no token found")
 
-func ResolveToken(ctx context.Context, token string) (string, error) {
-
-	if token == "" {
-		token = resolveTokenFromEnv()
-	}
+// Attempt is one source tried by ResolveToken.
+type Attempt struct {
+	// Source names the source, e.g. "DEPOT_TOKEN" or "oidc/github".
+	Source string
+	// Found is true if the token was taken from this source.
+	Found bool
+	// Skipped explains why the source had no token, e.g. "not set".
+	Skipped string
+	// Err is the error of the source, if any.
+	Err error
+}
 
-	if token == "" {
-		token = resolveTokenFromConfig()
+func (a Attempt) String() string {
+	switch {
+	case a.Found:
+		return a.Source + ": found"
+	case a.Err != nil:
+		return a.Source + ": " + a.Err.Error()
+	default:
+		return a.Source + ": skipped, " + a.Skipped
 	}
+}
 
-	if token == "" {
-		token = resolveTokenFromOIDC(ctx)
-	}
+// Resolution reports the sources ResolveToken tried, in order.
+type Resolution struct {
+	Attempts []Attempt
+}
 
-	if token == "" {
-		return "", ErrNoTokenFound
+// Source returns the name of the source the token was taken from, or "" if
+// no token was found.
+func (r Resolution) Source() string {
+	for _, a := range r.Attempts {
+		if a.Found {
+			return a.Source
+		}
 	}
-
-	return token, nil
+	return ""
 }
 
-func resolveTokenFromEnv() string {
-	return os.Getenv("DEPOT_TOKEN")
+// String returns one line per attempt, e.g. for CI logs.
+func (r Resolution) String() string {
+	lines := make([]string, 0, len(r.Attempts))
+	for _, a := range r.Attempts {
+		lines = append(lines, a.String())
+	}
+	return strings.Join(lines, "\n")
 }
 
-func resolveTokenFromConfig() string {
-	return config.GetApiToken()
+// ResolutionError is returned by ResolveToken when no source had a token.  It
+// matches ErrNoTokenFound and the errors of every source with errors.Is and
+// errors.As.
+type ResolutionError struct {
+	Resolution Resolution
 }
 
-func resolveTokenFromOIDC(ctx context.Context) string {
-	for _, provider := range oidc.Providers {
-		logger.DebugContext(ctx, "Trying OIDC provide", "provider", provider.Name())
-
-		token, err := provider.RetrieveToken(ctx)
-
-		if err != nil {
-			logger.DebugContext(ctx, "OIDC provider failed", "provider", provider.Name(), "error", err)
+func (e *ResolutionError) Error() string {
+	var failed []string
+	for _, a := range e.Resolution.Attempts {
+		if a.Err != nil {
+			failed = append(failed, a.String())
 		}
+	}
+	if len(failed) == 0 {
+		return ErrNoTokenFound.Error()
+	}
+	return fmt.Sprintf("%s (%s)", ErrNoTokenFound, strings.Join(failed, "; "))
+}
 
-		if token != "" {
-			return token
+func (e *ResolutionError) Unwrap() []error {
+	errs := []error{ErrNoTokenFound}
+	for _, a := range e.Resolution.Attempts {
+		if a.Err != nil {
+			errs = append(errs, a.Err)
 		}
 	}
+	return errs
+}
 
-	return ""
+// ResolveToken returns token if it is set, otherwise the token of the first
+// source of DefaultChain that has one.
+func ResolveToken(ctx context.Context, token string) (string, error) {
+	token, _, err := ResolveTokenWithReport(ctx, token)
+	return token, err
+}
+
+// ResolveTokenWithReport resolves the token like ResolveToken and reports
+// which sources were tried.
+func ResolveTokenWithReport(ctx context.Context, token string) (string, Resolution, error) {
+	chain := DefaultChain(PrependSource(ExplicitSource(token)))
+	return chain.Resolve(ctx)
 }
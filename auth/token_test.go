@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/depot/depot-go/internal/oidc"
+)
+
+type fakeProvider struct {
+	name  string
+	token string
+	err   error
+}
+
+func (p fakeProvider) Name() string { return p.name }
+
+func (p fakeProvider) RetrieveToken(ctx context.Context) (string, error) { return p.token, p.err }
+
+func setProviders(t *testing.T, providers ...oidc.OIDCProvider) {
+	t.Helper()
+	saved := oidc.Providers
+	oidc.Providers = providers
+	t.Cleanup(func() { oidc.Providers = saved })
+}
+
+func TestResolveTokenWithReport(t *testing.T) {
+	ctx := context.Background()
+	errExchange := errors.New("token exchange failed")
+	t.Setenv("DEPOT_TOKEN", "")
+	setProviders(t,
+		fakeProvider{name: "github", err: errExchange},
+		fakeProvider{name: "circleci"},
+		fakeProvider{name: "buildkite", token: "oidc-token"},
+	)
+
+	token, resolution, err := ResolveTokenWithReport(ctx, "")
+	if err != nil || token != "oidc-token" {
+		t.Fatalf("ResolveTokenWithReport() = %q, %v", token, err)
+	}
+	want := []string{
+		"explicit: skipped, not given",
+		"DEPOT_TOKEN: skipped, not set",
+		"config: skipped, no token in the config file",
+		"oidc/github: token exchange failed",
+		"oidc/circleci: skipped, no token available",
+		"oidc/buildkite: found",
+	}
+	if got := resolution.String(); got != strings.Join(want, "\n") {
+		t.Errorf("resolution =\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+	if resolution.Source() != "oidc/buildkite" {
+		t.Errorf("Source() = %q", resolution.Source())
+	}
+
+	t.Setenv("DEPOT_TOKEN", "env-token")
+	if _, resolution, _ := ResolveTokenWithReport(ctx, ""); resolution.Source() != "DEPOT_TOKEN" || len(resolution.Attempts) != 2 {
+		t.Errorf("resolution = %v, want DEPOT_TOKEN after two attempts", resolution)
+	}
+
+	t.Setenv("DEPOT_TOKEN", "")
+	setProviders(t, fakeProvider{name: "github", err: errExchange}, fakeProvider{name: "circleci"})
+	_, err = ResolveToken(ctx, "")
+	var resolutionErr *ResolutionError
+	if !errors.As(err, &resolutionErr) || !errors.Is(err, ErrNoTokenFound) || !errors.Is(err, errExchange) {
+		t.Fatalf("ResolveToken() error = %v, want a ResolutionError wrapping the provider error", err)
+	}
+	if got := err.Error(); got != "no token found (oidc/github: token exchange failed)" {
+		t.Errorf("Error() = %q", got)
+	}
+}
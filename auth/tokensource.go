@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expiryDelta is how long before a token expires it is refreshed so that
+// requests in flight are not rejected.
+const expiryDelta = time.Minute
+
+// TokenSource supplies the Depot API token used to authenticate requests.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticTokenSource returns a TokenSource that always returns the same token.
+func StaticTokenSource(token string) TokenSource {
+	return staticTokenSource(token)
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(ctx context.Context) (string, error) {
+	if s == "" {
+		return "", ErrNoTokenFound
+	}
+	return string(s), nil
+}
+
+// ResolvingTokenSource returns the TokenSource of [DefaultChain].
+func ResolvingTokenSource() TokenSource {
+	return DefaultChain().TokenSource()
+}
+
+type resolvingTokenSource struct {
+	chain  *Chain
+	mu     sync.Mutex
+	origin Source
+}
+
+// Token refreshes the token from the source it came from, or resolves it
+// from the whole chain if that source no longer has one.
+func (s *resolvingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.origin != nil {
+		if token, err := s.origin.Token(ctx); err == nil && token != "" {
+			return token, nil
+		}
+	}
+	token, origin, _, err := s.chain.resolve(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.origin = origin
+	return token, nil
+}
+
+// CachingTokenSource returns a TokenSource that returns the token of src
+// until shortly before the token's JWT exp claim.  If the refresh fails the
+// cached token is returned until it expires.  Tokens that are not JWTs with
+// an expiry are cached forever.  It is safe for concurrent use.
+func CachingTokenSource(src TokenSource) TokenSource {
+	return &cachingTokenSource{src: src}
+}
+
+type cachingTokenSource struct {
+	mu     sync.Mutex
+	src    TokenSource
+	token  string
+	expiry time.Time
+}
+
+func (s *cachingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || time.Now().Before(s.expiry.Add(-expiryDelta))) {
+		return s.token, nil
+	}
+
+	token, err := s.src.Token(ctx)
+	if err != nil {
+		// The cached token is still valid, only due for a refresh.
+		if s.token != "" && time.Now().Before(s.expiry) {
+			return s.token, nil
+		}
+		return "", err
+	}
+	s.token = token
+	s.expiry, _ = TokenExpiry(token)
+	return token, nil
+}
+
+// TokenExpiry returns the exp claim of a JWT.  ok is false if token is not a
+// JWT or has no expiry.  The signature is not verified.
+func TokenExpiry(token string) (expiry time.Time, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp *json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == nil {
+		return time.Time{}, false
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(exp)
+	return time.Unix(sec, int64((exp-float64(sec))*float64(time.Second))), true
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func jwt(exp time.Time) string {
+	claims := fmt.Sprintf(`{"sub":"repo:depot/depot-go","exp":%d}`, exp.Unix())
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+type countingTokenSource struct {
+	calls  int
+	tokens func(call int) string
+	err    error
+}
+
+func (s *countingTokenSource) Token(ctx context.Context) (string, error) {
+	s.calls++
+	if s.err != nil {
+		return "", s.err
+	}
+	return s.tokens(s.calls), nil
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	if got, ok := TokenExpiry(jwt(exp)); !ok || !got.Equal(exp) {
+		t.Errorf("TokenExpiry() = %v, %v, want %v", got, ok, exp)
+	}
+	for _, token := range []string{"depot_project_123", "a.b.c", "e30.e30.sig"} {
+		if _, ok := TokenExpiry(token); ok {
+			t.Errorf("TokenExpiry(%q) ok, want no expiry", token)
+		}
+	}
+}
+
+func TestCachingTokenSource(t *testing.T) {
+	ctx := context.Background()
+
+	static := &countingTokenSource{tokens: func(int) string { return "depot_project_123" }}
+	ts := CachingTokenSource(static)
+	for i := 0; i < 3; i++ {
+		if token, err := ts.Token(ctx); err != nil || token != "depot_project_123" {
+			t.Fatalf("Token() = %q, %v", token, err)
+		}
+	}
+	if static.calls != 1 {
+		t.Errorf("static token retrieved %d times, want 1", static.calls)
+	}
+
+	// The first token expires within expiryDelta and is refreshed on the next call.
+	oidc := &countingTokenSource{tokens: func(call int) string {
+		if call == 1 {
+			return jwt(time.Now().Add(expiryDelta / 2))
+		}
+		return jwt(time.Now().Add(time.Hour))
+	}}
+	ts = CachingTokenSource(oidc)
+	first, _ := ts.Token(ctx)
+	second, _ := ts.Token(ctx)
+	third, _ := ts.Token(ctx)
+	if first == second || second != third || oidc.calls != 2 {
+		t.Errorf("OIDC token retrieved %d times, want a refresh of the expiring token only", oidc.calls)
+	}
+
+	// A failed refresh falls back to the cached token until it expires.
+	expiring := jwt(time.Now().Add(expiryDelta / 2))
+	failing := &countingTokenSource{tokens: func(int) string { return expiring }}
+	ts = CachingTokenSource(failing)
+	if _, err := ts.Token(ctx); err != nil {
+		t.Fatal(err)
+	}
+	failing.err = errors.New("provider unavailable")
+	if token, err := ts.Token(ctx); err != nil || token != expiring {
+		t.Errorf("Token() = %q, %v, want the cached token", token, err)
+	}
+	if failing.calls != 2 {
+		t.Errorf("token retrieved %d times, want a refresh attempt", failing.calls)
+	}
+
+	expired := &countingTokenSource{tokens: func(int) string { return jwt(time.Now().Add(-time.Second)) }}
+	ts = CachingTokenSource(expired)
+	if _, err := ts.Token(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expired.err = errors.New("provider unavailable")
+	if _, err := ts.Token(ctx); !errors.Is(err, expired.err) {
+		t.Errorf("Token() error = %v, want the refresh error for an expired token", err)
+	}
+}
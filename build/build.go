@@ -3,15 +3,16 @@ package build
 import (
 	"context"
 	"errors"
-	"log"
+	"sync"
+	"time"
 
 	"connectrpc.com/connect"
 	depotapi "github.com/depot/depot-go/api"
 	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
-	"github.com/moby/buildkit/util/grpcerrors"
-	"google.golang.org/grpc/codes"
 )
 
+const finishTimeout = 30 * time.Second
+
 type Build struct {
 	ID               string
 	Token            string
@@ -19,19 +20,45 @@ type Build struct {
 	ProxyImage       string
 	// BuildURL is the URL to the build on the depot web UI.
 	BuildURL string
-	Finish   func(error)
+	// AdditionalCredentials authenticate to the registries of AdditionalTags.
+	AdditionalCredentials []Credentials
+	// AdditionalTags are tags the image is also exported with, e.g. to a Depot registry.
+	AdditionalTags []Tag
+	// ProfilerToken authenticates profile uploads.  Empty unless profiling is enabled.
+	ProfilerToken string
 
 	Response *connect.Response[cliv1.CreateBuildResponse]
+
+	client *depotapi.Client
+	finish *finishState
+}
+
+// Option configures a Build.
+type Option func(*Build)
+
+// WithClient uses client for all API requests of the build.
+// Without this option a client with the default configuration is used.
+func WithClient(client *depotapi.Client) Option {
+	return func(b *Build) {
+		b.client = client
+	}
 }
 
-func NewBuild(ctx context.Context, req *cliv1.CreateBuildRequest, token string) (Build, error) {
-	client := depotapi.NewBuildClient()
-	res, err := client.CreateBuild(ctx, depotapi.WithAuthentication(connect.NewRequest(req), token))
+// Client returns the API client used by the build.
+func (b *Build) Client() *depotapi.Client {
+	return b.client
+}
+
+// NewBuild registers a new build with the Depot API.  If token is empty the
+// token is taken from the client's token source.
+func NewBuild(ctx context.Context, req *cliv1.CreateBuildRequest, token string, opts ...Option) (Build, error) {
+	b := newBuild(opts...)
+	res, err := b.client.BuildService().CreateBuild(ctx, depotapi.WithAuthentication(connect.NewRequest(req), token))
 	if err != nil {
 		return Build{}, err
 	}
 
-	build, err := FromExistingBuild(ctx, res.Msg.BuildId, res.Msg.BuildToken)
+	build, err := FromExistingBuild(ctx, res.Msg.BuildId, res.Msg.BuildToken, WithClient(b.client))
 	if err != nil {
 		return Build{}, err
 	}
@@ -42,37 +69,71 @@ func NewBuild(ctx context.Context, req *cliv1.CreateBuildRequest, token string)
 	if res.Msg.GetRegistry() != nil {
 		build.ProxyImage = res.Msg.GetRegistry().ProxyImage
 	}
+	build.ProfilerToken = res.Msg.GetProfiler().GetToken()
+	for _, cred := range res.Msg.AdditionalCredentials {
+		build.AdditionalCredentials = append(build.AdditionalCredentials, Credentials{Host: cred.Host, Username: tokenUsername, Password: cred.Token})
+	}
+	for _, tag := range res.Msg.AdditionalTags {
+		build.AdditionalTags = append(build.AdditionalTags, Tag{Name: tag.Tag, Push: tag.Push})
+	}
 
 	return build, nil
 }
 
-func FromExistingBuild(ctx context.Context, buildID, token string) (Build, error) {
-	finish := func(buildErr error) {
-		client := depotapi.NewBuildClient()
-		req := cliv1.FinishBuildRequest{BuildId: buildID}
-		req.Result = &cliv1.FinishBuildRequest_Success{Success: &cliv1.FinishBuildRequest_BuildSuccess{}}
-		if buildErr != nil {
-			// Classify errors as canceled by user/ci or build error.
-			if errors.Is(buildErr, context.Canceled) {
-				// Context canceled would happen for steps that are not buildkitd.
-				req.Result = &cliv1.FinishBuildRequest_Canceled{Canceled: &cliv1.FinishBuildRequest_BuildCanceled{}}
-			} else if status, ok := grpcerrors.AsGRPCStatus(buildErr); ok && status.Code() == codes.Canceled {
-				// Cancelled by buildkitd happens during a remote buildkitd step.
-				req.Result = &cliv1.FinishBuildRequest_Canceled{Canceled: &cliv1.FinishBuildRequest_BuildCanceled{}}
-			} else {
-				errorMessage := buildErr.Error()
-				req.Result = &cliv1.FinishBuildRequest_Error{Error: &cliv1.FinishBuildRequest_BuildError{Error: errorMessage}}
-			}
-		}
-		_, err := client.FinishBuild(ctx, depotapi.WithAuthentication(connect.NewRequest(&req), token))
-		if err != nil {
-			log.Printf("error releasing builder: %v", err)
+func FromExistingBuild(ctx context.Context, buildID, token string, opts ...Option) (Build, error) {
+	b := newBuild(opts...)
+	b.ID = buildID
+	b.Token = token
+	b.finish = &finishState{}
+	return *b, nil
+}
+
+// finishState is shared by the copies of a Build so that it finishes once.
+type finishState struct {
+	once sync.Once
+	err  error
+}
+
+// Finish reports the result of the build to Depot: success if buildErr is
+// nil, canceled if it is a cancellation, or failed.  Only the first call
+// reports; later calls return its error.  The request uses its own timeout
+// so that the result is reported even if ctx is already canceled.
+func (b *Build) Finish(ctx context.Context, buildErr error) error {
+	if b.finish == nil {
+		b.finish = &finishState{}
+	}
+	b.finish.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finishTimeout)
+		defer cancel()
+
+		req := finishRequest(b.ID, buildErr)
+		_, b.finish.err = b.client.BuildService().FinishBuild(ctx, depotapi.WithAuthentication(connect.NewRequest(req), b.Token))
+	})
+	return b.finish.err
+}
+
+func finishRequest(buildID string, buildErr error) *cliv1.FinishBuildRequest {
+	req := &cliv1.FinishBuildRequest{BuildId: buildID}
+	req.Result = &cliv1.FinishBuildRequest_Success{Success: &cliv1.FinishBuildRequest_BuildSuccess{}}
+	if buildErr != nil {
+		// Cancellations by the user or CI are not build errors; timeouts are.
+		var canceled *CanceledError
+		if buildErr = ClassifyError(buildErr, nil); errors.As(buildErr, &canceled) {
+			req.Result = &cliv1.FinishBuildRequest_Canceled{Canceled: &cliv1.FinishBuildRequest_BuildCanceled{}}
+		} else {
+			req.Result = &cliv1.FinishBuildRequest_Error{Error: &cliv1.FinishBuildRequest_BuildError{Error: buildErr.Error()}}
 		}
 	}
+	return req
+}
 
-	return Build{
-		ID:     buildID,
-		Token:  token,
-		Finish: finish,
-	}, nil
+func newBuild(opts ...Option) *Build {
+	b := &Build{}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.client == nil {
+		b.client = depotapi.NewClient()
+	}
+	return b
 }
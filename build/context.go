@@ -0,0 +1,82 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"connectrpc.com/connect"
+	depotapi "github.com/depot/depot-go/api"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+// MaxDockerfileSize is the largest Dockerfile reported to Depot.  Larger
+// Dockerfiles are not reported.
+const MaxDockerfileSize = 1 << 20
+
+// ErrDockerfileTooLarge is returned for Dockerfiles larger than MaxDockerfileSize.
+var ErrDockerfileTooLarge = errors.New("dockerfile is too large to report")
+
+// ReadDockerfile reads the Dockerfile of the build for ReportBuildContext.
+// Its filename is relative to ContextDir.  It returns an error wrapping
+// ErrDockerfileTooLarge if the Dockerfile is larger than MaxDockerfileSize.
+func (d DockerfileBuild) ReadDockerfile() (*cliv1.Dockerfile, error) {
+	filename := d.dockerfile()
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	contents, err := io.ReadAll(io.LimitReader(f, MaxDockerfileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", filename, err)
+	}
+	if len(contents) > MaxDockerfileSize {
+		return nil, fmt.Errorf("%s: %w", filename, ErrDockerfileTooLarge)
+	}
+
+	return &cliv1.Dockerfile{Target: d.Target, Filename: d.relativeDockerfile(), Contents: string(contents)}, nil
+}
+
+// relativeDockerfile returns the path of the Dockerfile relative to the
+// context directory so that local paths are not reported.
+func (d DockerfileBuild) relativeDockerfile() string {
+	contextDir, contextErr := filepath.Abs(d.contextDir())
+	dockerfile, dockerfileErr := filepath.Abs(d.dockerfile())
+	if contextErr == nil && dockerfileErr == nil {
+		if rel, err := filepath.Rel(contextDir, dockerfile); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.Base(d.dockerfile())
+}
+
+// ReportBuildContext sends the Dockerfiles of builds to the Depot API.  A
+// bake-style build passes one DockerfileBuild per target.  Dockerfiles that
+// cannot be read or are larger than MaxDockerfileSize are skipped and their
+// errors returned after the others are reported.
+func (b *Build) ReportBuildContext(ctx context.Context, builds ...DockerfileBuild) error {
+	var (
+		dockerfiles []*cliv1.Dockerfile
+		errs        []error
+	)
+	for _, d := range builds {
+		dockerfile, err := d.ReadDockerfile()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dockerfiles = append(dockerfiles, dockerfile)
+	}
+
+	if len(dockerfiles) > 0 {
+		req := &cliv1.ReportBuildContextRequest{BuildId: b.ID, Dockerfiles: dockerfiles}
+		_, err := b.client.BuildService().ReportBuildContext(ctx, depotapi.WithAuthentication(connect.NewRequest(req), b.Token))
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
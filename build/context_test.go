@@ -0,0 +1,59 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/depot/depot-go/depottest"
+)
+
+func TestReportBuildContext(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Dockerfile", "FROM alpine\n")
+	write("api.Dockerfile", "FROM golang\n")
+	write("large.Dockerfile", strings.Repeat("#", MaxDockerfileSize+1))
+
+	server := depottest.NewServer()
+	defer server.Close()
+	state := server.AddBuild(depottest.Build{ProjectID: "project"})
+	b, err := FromExistingBuild(context.Background(), state.ID, state.Token, WithClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.ReportBuildContext(context.Background(),
+		DockerfileBuild{ContextDir: dir, Target: "web"},
+		DockerfileBuild{ContextDir: dir, Dockerfile: filepath.Join(dir, "missing.Dockerfile"), Target: "missing"},
+		DockerfileBuild{ContextDir: dir, Dockerfile: filepath.Join(dir, "api.Dockerfile"), Target: "api"},
+		DockerfileBuild{ContextDir: dir, Dockerfile: filepath.Join(dir, "large.Dockerfile"), Target: "large"},
+	)
+	if !errors.Is(err, fs.ErrNotExist) || !errors.Is(err, ErrDockerfileTooLarge) {
+		t.Errorf("ReportBuildContext() error = %v, want the missing and the large Dockerfile", err)
+	}
+
+	state, _ = server.Build(b.ID)
+	if len(state.Dockerfiles) != 2 {
+		t.Fatalf("reported %d Dockerfiles, want 2", len(state.Dockerfiles))
+	}
+	web, api := state.Dockerfiles[0], state.Dockerfiles[1]
+	if web.Target != "web" || web.Filename != "Dockerfile" || web.Contents != "FROM alpine\n" {
+		t.Errorf("web Dockerfile = %v", web)
+	}
+	if api.Target != "api" || api.Filename != "api.Dockerfile" || api.Contents != "FROM golang\n" {
+		t.Errorf("api Dockerfile = %v", api)
+	}
+
+	if err := b.ReportBuildContext(context.Background(), DockerfileBuild{ContextDir: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("ReportBuildContext() of a missing Dockerfile succeeded")
+	}
+}
@@ -0,0 +1,286 @@
+package build
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
+)
+
+// DockerfileBuild describes a Dockerfile build in the terms of `docker buildx build`.
+// It produces both the buildkit SolveOpt and the matching BuildOptions sent to
+// the Depot API when the build is created.
+type DockerfileBuild struct {
+	// Dockerfile is the path to the Dockerfile.  Defaults to Dockerfile in ContextDir.
+	Dockerfile string
+	// ContextDir is the build context directory.  Defaults to ".".
+	ContextDir string
+	// Target is the stage to build.
+	Target    string
+	BuildArgs map[string]string
+	Labels    map[string]string
+	// Platforms to build for, e.g. "linux/amd64".
+	Platforms []string
+	// Tags name the built image.
+	Tags []string
+	// Push pushes the image to its registry.
+	Push bool
+	// Load writes the image as a docker tarball to LoadOutput, e.g. a pipe to `docker load`.
+	Load       bool
+	LoadOutput func(map[string]string) (io.WriteCloser, error)
+	// CacheFrom and CacheTo use the buildx format, e.g. "type=registry,ref=repo/cache,mode=max".
+	// A value without a type is a registry reference.
+	CacheFrom []string
+	CacheTo   []string
+	// Secrets use the buildx format, e.g. "id=npmrc,src=$HOME/.npmrc" or "id=token,env=TOKEN".
+	Secrets []string
+	// SSH uses the buildx format, e.g. "default" or "github=$HOME/.ssh/id_ed25519".
+	SSH     []string
+	NoCache bool
+	// Session is added to the attachables of the solve, e.g. registry auth providers.
+	Session []session.Attachable
+}
+
+// SolveOpt returns the buildkit solve options for the build.
+func (d DockerfileBuild) SolveOpt() (client.SolveOpt, error) {
+	contextDir := d.contextDir()
+	dockerfile := d.dockerfile()
+
+	opt := client.SolveOpt{
+		Frontend: "dockerfile.v0",
+		FrontendAttrs: map[string]string{
+			"filename": filepath.Base(dockerfile),
+		},
+		LocalDirs: map[string]string{
+			"context":    contextDir,
+			"dockerfile": filepath.Dir(dockerfile),
+		},
+	}
+	if d.Target != "" {
+		opt.FrontendAttrs["target"] = d.Target
+	}
+	for k, v := range d.BuildArgs {
+		opt.FrontendAttrs["build-arg:"+k] = v
+	}
+	for k, v := range d.Labels {
+		opt.FrontendAttrs["label:"+k] = v
+	}
+	if len(d.Platforms) > 0 {
+		opt.FrontendAttrs["platform"] = strings.Join(d.Platforms, ",")
+	}
+	if d.NoCache {
+		opt.FrontendAttrs["no-cache"] = ""
+	}
+
+	exports, err := d.exports()
+	if err != nil {
+		return client.SolveOpt{}, err
+	}
+	opt.Exports = exports
+
+	for _, spec := range d.CacheFrom {
+		entry, err := parseCacheEntry(spec)
+		if err != nil {
+			return client.SolveOpt{}, err
+		}
+		opt.CacheImports = append(opt.CacheImports, entry)
+	}
+	for _, spec := range d.CacheTo {
+		entry, err := parseCacheEntry(spec)
+		if err != nil {
+			return client.SolveOpt{}, err
+		}
+		opt.CacheExports = append(opt.CacheExports, entry)
+	}
+
+	if len(d.Secrets) > 0 {
+		secrets, err := parseSecrets(d.Secrets)
+		if err != nil {
+			return client.SolveOpt{}, err
+		}
+		opt.Session = append(opt.Session, secrets)
+	}
+	if len(d.SSH) > 0 {
+		ssh, err := parseSSH(d.SSH)
+		if err != nil {
+			return client.SolveOpt{}, err
+		}
+		opt.Session = append(opt.Session, ssh)
+	}
+	opt.Session = append(opt.Session, d.Session...)
+
+	return opt, nil
+}
+
+// BuildOptions returns the description of the build for the Depot API.  It
+// returns the same output errors as SolveOpt, so an invalid build is rejected
+// before it is registered.
+func (d DockerfileBuild) BuildOptions() (*cliv1.BuildOptions, error) {
+	exports, err := d.exports()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &cliv1.BuildOptions{
+		Command: cliv1.Command_COMMAND_BUILD,
+		Tags:    d.Tags,
+		Push:    d.Push,
+		Load:    d.Load,
+	}
+	if d.Target != "" {
+		opts.TargetName = &d.Target
+	}
+	for _, export := range exports {
+		opts.Outputs = append(opts.Outputs, &cliv1.BuildOutput{Kind: export.Type, Attributes: export.Attrs})
+	}
+	return opts, nil
+}
+
+// CreateBuildRequest returns the request registering the build in projectID.
+func (d DockerfileBuild) CreateBuildRequest(projectID string) (*cliv1.CreateBuildRequest, error) {
+	opts, err := d.BuildOptions()
+	if err != nil {
+		return nil, err
+	}
+	return &cliv1.CreateBuildRequest{
+		ProjectId: projectID,
+		Options:   []*cliv1.BuildOptions{opts},
+	}, nil
+}
+
+func (d DockerfileBuild) contextDir() string {
+	if d.ContextDir == "" {
+		return "."
+	}
+	return d.ContextDir
+}
+
+func (d DockerfileBuild) dockerfile() string {
+	if d.Dockerfile == "" {
+		return filepath.Join(d.contextDir(), "Dockerfile")
+	}
+	return d.Dockerfile
+}
+
+func (d DockerfileBuild) exports() ([]client.ExportEntry, error) {
+	var exports []client.ExportEntry
+	name := strings.Join(d.Tags, ",")
+
+	if d.Push || len(d.Tags) > 0 {
+		attrs := map[string]string{"oci-mediatypes": "true"}
+		if name != "" {
+			attrs["name"] = name
+		}
+		if d.Push {
+			if name == "" {
+				return nil, errors.New("push requires at least one tag")
+			}
+			attrs["push"] = "true"
+		}
+		exports = append(exports, client.ExportEntry{Type: client.ExporterImage, Attrs: attrs})
+	}
+
+	if d.Load {
+		if d.LoadOutput == nil {
+			return nil, errors.New("load requires LoadOutput")
+		}
+		attrs := map[string]string{}
+		if name != "" {
+			attrs["name"] = name
+		}
+		exports = append(exports, client.ExportEntry{Type: client.ExporterDocker, Attrs: attrs, Output: d.LoadOutput})
+	}
+
+	return exports, nil
+}
+
+func parseCacheEntry(spec string) (client.CacheOptionsEntry, error) {
+	if !strings.Contains(spec, "=") {
+		return client.CacheOptionsEntry{Type: "registry", Attrs: map[string]string{"ref": spec}}, nil
+	}
+
+	attrs, err := parseAttrs(spec)
+	if err != nil {
+		return client.CacheOptionsEntry{}, fmt.Errorf("invalid cache %q: %w", spec, err)
+	}
+	entry := client.CacheOptionsEntry{Type: attrs["type"], Attrs: attrs}
+	delete(attrs, "type")
+	if entry.Type == "" {
+		entry.Type = "registry"
+	}
+	return entry, nil
+}
+
+func parseSecrets(specs []string) (session.Attachable, error) {
+	var sources []secretsprovider.Source
+	for _, spec := range specs {
+		attrs, err := parseAttrs(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret %q: %w", spec, err)
+		}
+
+		source := secretsprovider.Source{ID: attrs["id"], Env: attrs["env"]}
+		source.FilePath = attrs["src"]
+		if source.FilePath == "" {
+			source.FilePath = attrs["source"]
+		}
+		switch attrs["type"] {
+		case "", "file":
+		case "env":
+			if source.Env == "" {
+				source.Env, source.FilePath = source.FilePath, ""
+			}
+		default:
+			return nil, fmt.Errorf("invalid secret %q: unsupported type %q", spec, attrs["type"])
+		}
+		if source.ID == "" {
+			return nil, fmt.Errorf("invalid secret %q: missing id", spec)
+		}
+		sources = append(sources, source)
+	}
+
+	store, err := secretsprovider.NewStore(sources)
+	if err != nil {
+		return nil, err
+	}
+	return secretsprovider.NewSecretProvider(store), nil
+}
+
+func parseSSH(specs []string) (session.Attachable, error) {
+	configs := make([]sshprovider.AgentConfig, 0, len(specs))
+	for _, spec := range specs {
+		id, paths, _ := strings.Cut(spec, "=")
+		config := sshprovider.AgentConfig{ID: id}
+		if paths != "" {
+			config.Paths = strings.Split(paths, ",")
+		}
+		configs = append(configs, config)
+	}
+	return sshprovider.NewSSHAgentProvider(configs)
+}
+
+// parseAttrs parses comma separated key=value pairs.
+func parseAttrs(spec string) (map[string]string, error) {
+	fields, err := csv.NewReader(strings.NewReader(spec)).Read()
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string]string, len(fields))
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", field)
+		}
+		attrs[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return attrs, nil
+}
@@ -0,0 +1,92 @@
+package build
+
+import (
+	"testing"
+
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+func TestDockerfileBuild(t *testing.T) {
+	d := DockerfileBuild{
+		Dockerfile: "docker/app.Dockerfile",
+		ContextDir: "src",
+		Target:     "release",
+		BuildArgs:  map[string]string{"VERSION": "1.2.3"},
+		Labels:     map[string]string{"team": "build"},
+		Platforms:  []string{"linux/amd64", "linux/arm64"},
+		Tags:       []string{"example/app:1.2.3", "example/app:latest"},
+		Push:       true,
+		CacheFrom:  []string{"example/app:cache"},
+		CacheTo:    []string{"type=registry,ref=example/app:cache,mode=max"},
+		Secrets:    []string{"id=token,env=TOKEN"},
+	}
+
+	opt, err := d.SolveOpt()
+	if err != nil {
+		t.Fatalf("SolveOpt() error = %v", err)
+	}
+
+	wantAttrs := map[string]string{
+		"filename":          "app.Dockerfile",
+		"target":            "release",
+		"build-arg:VERSION": "1.2.3",
+		"label:team":        "build",
+		"platform":          "linux/amd64,linux/arm64",
+	}
+	for k, v := range wantAttrs {
+		if opt.FrontendAttrs[k] != v {
+			t.Errorf("FrontendAttrs[%q] = %q, want %q", k, opt.FrontendAttrs[k], v)
+		}
+	}
+	if opt.LocalDirs["context"] != "src" || opt.LocalDirs["dockerfile"] != "docker" {
+		t.Errorf("LocalDirs = %v, want context src and dockerfile docker", opt.LocalDirs)
+	}
+	if len(opt.Exports) != 1 || opt.Exports[0].Attrs["name"] != "example/app:1.2.3,example/app:latest" || opt.Exports[0].Attrs["push"] != "true" {
+		t.Errorf("Exports = %+v, want a pushed image with both tags", opt.Exports)
+	}
+	if len(opt.CacheImports) != 1 || opt.CacheImports[0].Type != "registry" || opt.CacheImports[0].Attrs["ref"] != "example/app:cache" {
+		t.Errorf("CacheImports = %+v, want registry example/app:cache", opt.CacheImports)
+	}
+	if len(opt.CacheExports) != 1 || opt.CacheExports[0].Attrs["mode"] != "max" {
+		t.Errorf("CacheExports = %+v, want mode=max", opt.CacheExports)
+	}
+	if len(opt.Session) != 1 {
+		t.Errorf("Session = %d attachables, want the secrets provider", len(opt.Session))
+	}
+
+	req, err := d.CreateBuildRequest("project")
+	if err != nil {
+		t.Fatalf("CreateBuildRequest() error = %v", err)
+	}
+	options := req.Options[0]
+	if req.ProjectId != "project" || options.Command != cliv1.Command_COMMAND_BUILD || !options.Push || options.GetTargetName() != "release" {
+		t.Errorf("CreateBuildRequest() = %v, want a pushed build of target release", req)
+	}
+	if len(options.Tags) != 2 || len(options.Outputs) != 1 || options.Outputs[0].Kind != "image" {
+		t.Errorf("BuildOptions() = %v, want both tags and an image output", options)
+	}
+}
+
+func TestDockerfileBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		d    DockerfileBuild
+		// invalidOutputs are also rejected by BuildOptions.
+		invalidOutputs bool
+	}{
+		{name: "push without tags", d: DockerfileBuild{Push: true}, invalidOutputs: true},
+		{name: "load without output", d: DockerfileBuild{Load: true}, invalidOutputs: true},
+		{name: "secret without id", d: DockerfileBuild{Secrets: []string{"src=/tmp/secret"}}},
+		{name: "malformed cache", d: DockerfileBuild{CacheTo: []string{"type=registry,ref"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.d.SolveOpt(); err == nil {
+				t.Errorf("SolveOpt() error = nil, want an error")
+			}
+			if _, err := tt.d.BuildOptions(); (err != nil) != tt.invalidOutputs {
+				t.Errorf("BuildOptions() error = %v, want error %v", err, tt.invalidOutputs)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/solver/errdefs"
+	"github.com/moby/buildkit/util/grpcerrors"
+	"github.com/opencontainers/go-digest"
+	"google.golang.org/grpc/codes"
+)
+
+// CanceledError is a build canceled by the user or CI.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string { return "build canceled: " + e.Err.Error() }
+func (e *CanceledError) Unwrap() error { return e.Err }
+
+// TimeoutError is a build that exceeded its deadline.
+type TimeoutError struct {
+	Err error
+}
+
+func (e *TimeoutError) Error() string { return "build timed out: " + e.Err.Error() }
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// SolveError is a failed build step.
+type SolveError struct {
+	// Digest is the buildkit digest of the failed vertex.
+	Digest string
+	// Vertex is the name of the failed step, e.g. "[2/3] RUN make", if known.
+	Vertex string
+	// ExitCode is the exit code of the failed process, or -1 if unknown.
+	ExitCode int
+	Err      error
+}
+
+func (e *SolveError) Error() string {
+	var b strings.Builder
+	b.WriteString("step")
+	if e.Vertex != "" {
+		fmt.Fprintf(&b, " %q", e.Vertex)
+	}
+	b.WriteString(" failed")
+	if e.ExitCode >= 0 {
+		fmt.Fprintf(&b, " with exit code %d", e.ExitCode)
+	}
+	return b.String() + ": " + e.Err.Error()
+}
+
+func (e *SolveError) Unwrap() error { return e.Err }
+
+// FrontendError is an invalid build definition, e.g. a Dockerfile syntax error.
+type FrontendError struct {
+	Filename string
+	// Line is the line of the error, or 0 if unknown.
+	Line int
+	Err  error
+}
+
+func (e *FrontendError) Error() string {
+	location := e.Filename
+	if e.Line > 0 {
+		location += ":" + strconv.Itoa(e.Line)
+	}
+	if location == "" {
+		return "invalid build definition: " + e.Err.Error()
+	}
+	return "invalid build definition at " + location + ": " + e.Err.Error()
+}
+
+func (e *FrontendError) Unwrap() error { return e.Err }
+
+// RegistryError is a failure to push to or authenticate with a registry.
+type RegistryError struct {
+	// Auth is true if the registry rejected the credentials.
+	Auth bool
+	Err  error
+}
+
+func (e *RegistryError) Error() string {
+	if e.Auth {
+		return "registry authentication failed: " + e.Err.Error()
+	}
+	return "registry push failed: " + e.Err.Error()
+}
+
+func (e *RegistryError) Unwrap() error { return e.Err }
+
+var (
+	exitCodeRe     = regexp.MustCompile(`exit code: (\d+)`)
+	registryAuthRe = regexp.MustCompile(`(?i)failed to authorize|failed to fetch (oauth|anonymous) token|401 Unauthorized|403 Forbidden|insufficient_scope|denied: `)
+	registryPushRe = regexp.MustCompile(`(?i)failed to push|failed to export image`)
+)
+
+// ClassifyError returns err as one of CanceledError, TimeoutError,
+// SolveError, FrontendError or RegistryError, or err itself if it is none of
+// them.  The names of failed steps are looked up in timings, which may be nil.
+// Only errors outside build steps, e.g. of exporters, are RegistryErrors.
+func ClassifyError(err error, timings *Timings) error {
+	if err == nil || isClassified(err) {
+		return err
+	}
+
+	status, isStatus := grpcerrors.AsGRPCStatus(err)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), isStatus && status.Code() == codes.DeadlineExceeded:
+		return &TimeoutError{Err: err}
+	case errors.Is(err, context.Canceled), isStatus && status.Code() == codes.Canceled:
+		return &CanceledError{Err: err}
+	}
+
+	// Vertex errors come first: the message of a failed step includes its
+	// command line, which may look like a registry error.
+	msg := err.Error()
+	var vertexErr *errdefs.VertexError
+	if errors.As(err, &vertexErr) {
+		solveErr := &SolveError{Digest: vertexErr.Digest, ExitCode: -1, Err: err}
+		if timings != nil {
+			solveErr.Vertex = timings.name(digest.Digest(vertexErr.Digest))
+		}
+		if m := exitCodeRe.FindStringSubmatch(msg); m != nil {
+			solveErr.ExitCode, _ = strconv.Atoi(m[1])
+		}
+		return solveErr
+	}
+
+	if registryAuthRe.MatchString(msg) {
+		return &RegistryError{Auth: true, Err: err}
+	}
+	if registryPushRe.MatchString(msg) {
+		return &RegistryError{Err: err}
+	}
+
+	if sources := errdefs.Sources(err); len(sources) > 0 {
+		frontendErr := &FrontendError{Err: err}
+		source := sources[len(sources)-1]
+		if source.Info != nil {
+			frontendErr.Filename = source.Info.Filename
+		}
+		if len(source.Ranges) > 0 {
+			frontendErr.Line = int(source.Ranges[0].Start.Line)
+		}
+		return frontendErr
+	}
+
+	return err
+}
+
+func isClassified(err error) bool {
+	var (
+		canceled *CanceledError
+		timeout  *TimeoutError
+		solve    *SolveError
+		frontend *FrontendError
+		registry *RegistryError
+	)
+	return errors.As(err, &canceled) || errors.As(err, &timeout) || errors.As(err, &solve) ||
+		errors.As(err, &frontend) || errors.As(err, &registry)
+}
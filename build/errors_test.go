@@ -0,0 +1,142 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/solver/errdefs"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyError(t *testing.T) {
+	run := digest.FromString("run")
+	timings := NewTimings()
+	timings.record(&client.SolveStatus{Vertexes: []*client.Vertex{{Digest: run, Name: "[2/2] RUN make"}}})
+
+	source := errdefs.Source{
+		Info:   &pb.SourceInfo{Filename: "Dockerfile"},
+		Ranges: []*pb.Range{{Start: pb.Position{Line: 3}}},
+	}
+	processErr := errors.New(`process "/bin/sh -c make" did not complete successfully: exit code: 2`)
+
+	tests := []struct {
+		name  string
+		err   error
+		check func(t *testing.T, err error)
+	}{
+		{
+			name: "canceled",
+			err:  fmt.Errorf("solve: %w", context.Canceled),
+			check: func(t *testing.T, err error) {
+				var target *CanceledError
+				if !errors.As(err, &target) {
+					t.Errorf("error = %v, want CanceledError", err)
+				}
+			},
+		},
+		{
+			name: "buildkit canceled",
+			err:  status.Error(codes.Canceled, "context canceled"),
+			check: func(t *testing.T, err error) {
+				var target *CanceledError
+				if !errors.As(err, &target) {
+					t.Errorf("error = %v, want CanceledError", err)
+				}
+			},
+		},
+		{
+			name: "deadline exceeded",
+			err:  fmt.Errorf("solve: %w", context.DeadlineExceeded),
+			check: func(t *testing.T, err error) {
+				var target *TimeoutError
+				if !errors.As(err, &target) || !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("error = %v, want TimeoutError", err)
+				}
+			},
+		},
+		{
+			name: "solve",
+			err:  source.WrapError(errdefs.WrapVertex(processErr, run)),
+			check: func(t *testing.T, err error) {
+				var target *SolveError
+				if !errors.As(err, &target) {
+					t.Fatalf("error = %v, want SolveError", err)
+				}
+				if target.Vertex != "[2/2] RUN make" || target.ExitCode != 2 || target.Digest != run.String() {
+					t.Errorf("SolveError = %+v", target)
+				}
+				if !errors.Is(err, processErr) {
+					t.Errorf("SolveError does not wrap the original error")
+				}
+			},
+		},
+		{
+			name: "solve with registry output",
+			err:  errdefs.WrapVertex(errors.New(`process "/bin/sh -c docker push example/app || echo 'denied: 403 Forbidden'" did not complete successfully: exit code: 1`), run),
+			check: func(t *testing.T, err error) {
+				var target *SolveError
+				if !errors.As(err, &target) || target.Vertex != "[2/2] RUN make" || target.ExitCode != 1 {
+					t.Errorf("error = %v, want SolveError", err)
+				}
+			},
+		},
+		{
+			name: "frontend",
+			err:  source.WrapError(errors.New("dockerfile parse error: unknown instruction: RUNN")),
+			check: func(t *testing.T, err error) {
+				var target *FrontendError
+				if !errors.As(err, &target) || target.Filename != "Dockerfile" || target.Line != 3 {
+					t.Errorf("error = %#v, want FrontendError at Dockerfile:3", err)
+				}
+			},
+		},
+		{
+			name: "registry auth",
+			err:  errors.New("failed to push example/app:latest: failed to authorize: failed to fetch oauth token: 401 Unauthorized"),
+			check: func(t *testing.T, err error) {
+				var target *RegistryError
+				if !errors.As(err, &target) || !target.Auth {
+					t.Errorf("error = %v, want auth RegistryError", err)
+				}
+			},
+		},
+		{
+			name: "registry push",
+			err:  errors.New("failed to push example/app:latest: unexpected status: 500 Internal Server Error"),
+			check: func(t *testing.T, err error) {
+				var target *RegistryError
+				if !errors.As(err, &target) || target.Auth {
+					t.Errorf("error = %v, want push RegistryError", err)
+				}
+			},
+		},
+		{
+			name: "other",
+			err:  errors.New("boom"),
+			check: func(t *testing.T, err error) {
+				if err.Error() != "boom" {
+					t.Errorf("error = %v, want it unchanged", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ClassifyError(tt.err, timings)
+			tt.check(t, err)
+			if again := ClassifyError(err, timings); again != err {
+				t.Errorf("classifying twice = %v, want %v", again, err)
+			}
+		})
+	}
+
+	if ClassifyError(nil, nil) != nil {
+		t.Error("ClassifyError(nil) != nil")
+	}
+}
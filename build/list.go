@@ -0,0 +1,127 @@
+package build
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"time"
+
+	"connectrpc.com/connect"
+	depotapi "github.com/depot/depot-go/api"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+type listOptions struct {
+	client        *depotapi.Client
+	pageSize      int32
+	statuses      []cliv1.BuildStatus
+	createdAfter  time.Time
+	createdBefore time.Time
+}
+
+// ListOption configures List.  An Option such as WithClient is a ListOption
+// too, so project-level calls are configured like builds.
+type ListOption interface {
+	applyList(*listOptions)
+}
+
+type listOptionFunc func(*listOptions)
+
+func (f listOptionFunc) applyList(o *listOptions) { f(o) }
+
+func (opt Option) applyList(o *listOptions) {
+	b := &Build{}
+	opt(b)
+	if b.client != nil {
+		o.client = b.client
+	}
+}
+
+func newListOptions(opts ...ListOption) *listOptions {
+	o := &listOptions{}
+	for _, opt := range opts {
+		opt.applyList(o)
+	}
+	if o.client == nil {
+		o.client = depotapi.NewClient()
+	}
+	return o
+}
+
+// WithPageSize sets the number of builds requested per page.
+func WithPageSize(size int32) ListOption {
+	return listOptionFunc(func(o *listOptions) {
+		o.pageSize = size
+	})
+}
+
+// WithStatus lists only builds with one of statuses.
+func WithStatus(statuses ...cliv1.BuildStatus) ListOption {
+	return listOptionFunc(func(o *listOptions) {
+		o.statuses = append(o.statuses, statuses...)
+	})
+}
+
+// WithCreatedAfter lists only builds created at or after t.
+func WithCreatedAfter(t time.Time) ListOption {
+	return listOptionFunc(func(o *listOptions) {
+		o.createdAfter = t
+	})
+}
+
+// WithCreatedBefore lists only builds created before t.
+func WithCreatedBefore(t time.Time) ListOption {
+	return listOptionFunc(func(o *listOptions) {
+		o.createdBefore = t
+	})
+}
+
+func (o *listOptions) match(b *cliv1.Build) bool {
+	if len(o.statuses) > 0 && !slices.Contains(o.statuses, b.Status) {
+		return false
+	}
+	created := b.CreatedAt.AsTime()
+	if !o.createdAfter.IsZero() && created.Before(o.createdAfter) {
+		return false
+	}
+	if !o.createdBefore.IsZero() && !created.Before(o.createdBefore) {
+		return false
+	}
+	return true
+}
+
+// List returns the builds of projectID, newest first, following pages as the
+// sequence is consumed.  If token is empty the token is taken from the
+// client's token source.  An error ends the sequence.
+func List(ctx context.Context, projectID, token string, opts ...ListOption) iter.Seq2[*cliv1.Build, error] {
+	return newListOptions(opts...).list(ctx, projectID, token)
+}
+
+func (o *listOptions) list(ctx context.Context, projectID, token string) iter.Seq2[*cliv1.Build, error] {
+	return func(yield func(*cliv1.Build, error) bool) {
+		pageToken := ""
+		for {
+			req := &cliv1.ListBuildsRequest{ProjectId: projectID, PageSize: o.pageSize, PageToken: pageToken}
+			res, err := o.client.BuildService().ListBuilds(ctx, depotapi.WithAuthentication(connect.NewRequest(req), token))
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, b := range res.Msg.Builds {
+				// Builds are listed newest first, so the rest are older still.
+				if !o.createdAfter.IsZero() && b.CreatedAt.AsTime().Before(o.createdAfter) {
+					return
+				}
+				if o.match(b) && !yield(b, nil) {
+					return
+				}
+			}
+
+			pageToken = res.Msg.NextPageToken
+			if pageToken == "" {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/depottest"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
+)
+
+func TestList(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	start := time.Now().Truncate(time.Second)
+	statuses := []cliv1.BuildStatus{
+		cliv1.BuildStatus_BUILD_STATUS_FINISHED,
+		cliv1.BuildStatus_BUILD_STATUS_FAILED,
+		cliv1.BuildStatus_BUILD_STATUS_FINISHED,
+		cliv1.BuildStatus_BUILD_STATUS_RUNNING,
+		cliv1.BuildStatus_BUILD_STATUS_FINISHED,
+	}
+	for i, status := range statuses {
+		server.AddBuild(depottest.Build{ProjectID: "project", Status: status, CreatedAt: start.Add(time.Duration(i) * time.Minute)})
+	}
+	server.AddBuild(depottest.Build{ProjectID: "other"})
+
+	tests := []struct {
+		name string
+		opts []ListOption
+		want []string
+	}{
+		{
+			name: "all",
+			want: []string{"build-5", "build-4", "build-3", "build-2", "build-1"},
+		},
+		{
+			name: "status",
+			opts: []ListOption{WithStatus(cliv1.BuildStatus_BUILD_STATUS_FINISHED)},
+			want: []string{"build-5", "build-3", "build-1"},
+		},
+		{
+			name: "created window",
+			opts: []ListOption{WithCreatedAfter(start.Add(time.Minute)), WithCreatedBefore(start.Add(3 * time.Minute))},
+			want: []string{"build-3", "build-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]ListOption{WithClient(server.Client()), WithPageSize(2)}, tt.opts...)
+			var ids []string
+			for b, err := range List(context.Background(), "project", "", opts...) {
+				if err != nil {
+					t.Fatalf("List() error = %v", err)
+				}
+				ids = append(ids, b.Id)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("List() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	// Listing stops at the first build older than WithCreatedAfter, on the
+	// second of three pages.
+	calls := len(server.Calls(cliv1connect.BuildServiceListBuildsProcedure))
+	for _, err := range List(context.Background(), "project", "", WithClient(server.Client()), WithPageSize(2), WithCreatedAfter(start.Add(3*time.Minute))) {
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+	}
+	if got := len(server.Calls(cliv1connect.BuildServiceListBuildsProcedure)) - calls; got != 2 {
+		t.Errorf("listing the newest builds made %d requests, want 2", got)
+	}
+
+	calls = len(server.Calls(cliv1connect.BuildServiceListBuildsProcedure))
+	for range List(context.Background(), "project", "", WithClient(server.Client()), WithPageSize(2)) {
+		break
+	}
+	if got := len(server.Calls(cliv1connect.BuildServiceListBuildsProcedure)) - calls; got != 1 {
+		t.Errorf("stopping after the first build made %d requests, want 1", got)
+	}
+
+	server.FailNext(cliv1connect.BuildServiceListBuildsProcedure, connect.NewError(connect.CodeUnavailable, errors.New("down")))
+	for _, err := range List(context.Background(), "project", "", WithClient(server.Client())) {
+		if connect.CodeOf(err) != connect.CodeUnavailable {
+			t.Errorf("List() error = %v, want unavailable", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime/pprof"
+
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Profile kinds uploaded by a Profiler.
+const (
+	ProfileCPU     = "cpu"
+	ProfileHeap    = "heap"
+	ProfileTimings = "timings"
+)
+
+// ErrNoProfilerToken is returned when profiling a build Depot did not return
+// a profiler token for.
+var ErrNoProfilerToken = errors.New("build has no profiler token")
+
+// ErrNoUploader is returned when starting a profiler without an Uploader.
+var ErrNoUploader = errors.New("profiler has no uploader")
+
+// Profile is a profile of the process driving a build.
+type Profile struct {
+	// Kind is one of ProfileCPU, ProfileHeap or ProfileTimings.
+	Kind string
+	// Data is a pprof profile, or the JSON ReportTimingsRequest for timings.
+	Data []byte
+}
+
+// Uploader uploads the profiles of a build authenticated with its profiler token.
+type Uploader interface {
+	Upload(ctx context.Context, token string, profiles []Profile) error
+}
+
+// UploaderFunc adapts a function to an Uploader.
+type UploaderFunc func(ctx context.Context, token string, profiles []Profile) error
+
+// Upload calls f.
+func (f UploaderFunc) Upload(ctx context.Context, token string, profiles []Profile) error {
+	return f(ctx, token, profiles)
+}
+
+// Profiler profiles the CPU and heap of this process and the solve timings
+// while a build runs.
+type Profiler struct {
+	build    *Build
+	uploader Uploader
+	timings  *Timings
+	cpu      bytes.Buffer
+}
+
+// StartProfiler starts profiling the CPU of this process.  timings may be nil.
+// Only one profiler can run at a time.
+func (b *Build) StartProfiler(uploader Uploader, timings *Timings) (*Profiler, error) {
+	if f, ok := uploader.(UploaderFunc); uploader == nil || ok && f == nil {
+		return nil, ErrNoUploader
+	}
+	if b.ProfilerToken == "" {
+		return nil, ErrNoProfilerToken
+	}
+
+	p := &Profiler{build: b, uploader: uploader, timings: timings}
+	if err := pprof.StartCPUProfile(&p.cpu); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Stop stops profiling, captures the heap and uploads the profiles.
+func (p *Profiler) Stop(ctx context.Context) error {
+	pprof.StopCPUProfile()
+	profiles := []Profile{{Kind: ProfileCPU, Data: p.cpu.Bytes()}}
+
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return err
+	}
+	profiles = append(profiles, Profile{Kind: ProfileHeap, Data: heap.Bytes()})
+
+	if p.timings != nil {
+		data, err := protojson.Marshal(&cliv1.ReportTimingsRequest{BuildId: p.build.ID, BuildSteps: p.timings.Steps()})
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, Profile{Kind: ProfileTimings, Data: data})
+	}
+
+	return p.uploader.Upload(ctx, p.build.ProfilerToken, profiles)
+}
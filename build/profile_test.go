@@ -0,0 +1,70 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/depot/depot-go/depottest"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+func TestProfiler(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	req := &cliv1.CreateBuildRequest{ProjectId: "project"}
+	var (
+		gotToken string
+		kinds    []string
+	)
+	uploader := UploaderFunc(func(ctx context.Context, token string, profiles []Profile) error {
+		gotToken = token
+		for _, profile := range profiles {
+			if len(profile.Data) == 0 {
+				t.Errorf("%s profile is empty", profile.Kind)
+			}
+			kinds = append(kinds, profile.Kind)
+		}
+		return nil
+	})
+
+	b, err := NewBuild(ctx, req, "", WithClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.StartProfiler(uploader, nil); !errors.Is(err, ErrNoProfilerToken) {
+		t.Errorf("StartProfiler() error = %v, want ErrNoProfilerToken", err)
+	}
+
+	server.SetProfiling(true)
+	b, err = NewBuild(ctx, req, "", WithClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ProfilerToken != "profiler-"+b.ID {
+		t.Errorf("ProfilerToken = %q", b.ProfilerToken)
+	}
+
+	for _, nilUploader := range []Uploader{nil, UploaderFunc(nil)} {
+		if _, err := b.StartProfiler(nilUploader, nil); !errors.Is(err, ErrNoUploader) {
+			t.Errorf("StartProfiler(%#v) error = %v, want ErrNoUploader", nilUploader, err)
+		}
+	}
+
+	timings := NewTimings()
+	p, err := b.StartProfiler(uploader, timings)
+	if err != nil {
+		t.Fatalf("StartProfiler() error = %v", err)
+	}
+	if err := p.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if gotToken != b.ProfilerToken {
+		t.Errorf("uploaded with token %q, want %q", gotToken, b.ProfilerToken)
+	}
+	if len(kinds) != 3 || kinds[0] != ProfileCPU || kinds[1] != ProfileHeap || kinds[2] != ProfileTimings {
+		t.Errorf("uploaded profiles %v", kinds)
+	}
+}
@@ -0,0 +1,84 @@
+package build
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	depotapi "github.com/depot/depot-go/api"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth/authprovider"
+)
+
+// Registry is the Depot registry holding images saved with BuildOptions.Save.
+const Registry = "registry.depot.dev"
+
+// tokenUsername is the username Depot registries expect with a token.
+const tokenUsername = "x-token"
+
+// Credentials authenticate to a registry.
+type Credentials struct {
+	Host     string
+	Username string
+	Password string
+}
+
+// PullImage returns the reference of the image saved by buildID in projectID.
+func PullImage(projectID, buildID string) string {
+	return Registry + "/" + projectID + ":" + buildID
+}
+
+// PullCredentials returns credentials to pull the image saved by the build
+// from the Depot registry.
+func (b *Build) PullCredentials(ctx context.Context) (Credentials, error) {
+	return pullCredentials(ctx, b.client, &cliv1.GetPullTokenRequest{BuildId: &b.ID}, b.Token)
+}
+
+// ProjectPullCredentials returns credentials to pull the images saved by the
+// builds of projectID from the Depot registry.  If token is empty the token is
+// taken from the client's token source.
+func ProjectPullCredentials(ctx context.Context, projectID, token string, opts ...Option) (Credentials, error) {
+	b := newBuild(opts...)
+	return pullCredentials(ctx, b.client, &cliv1.GetPullTokenRequest{ProjectId: &projectID}, token)
+}
+
+func pullCredentials(ctx context.Context, client *depotapi.Client, req *cliv1.GetPullTokenRequest, token string) (Credentials, error) {
+	res, err := client.BuildService().GetPullToken(ctx, depotapi.WithAuthentication(connect.NewRequest(req), token))
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{Host: Registry, Username: tokenUsername, Password: res.Msg.Token}, nil
+}
+
+// AuthProvider returns a buildkit session attachable serving creds to
+// buildkit, e.g. for a Dockerfile FROM an image in the Depot registry.
+func AuthProvider(creds ...Credentials) session.Attachable {
+	cfg := configfile.New("")
+	for _, c := range creds {
+		cfg.AuthConfigs[c.Host] = c.authConfig()
+	}
+	return authprovider.NewDockerAuthProvider(cfg, nil)
+}
+
+// WriteDockerConfig stores creds in the docker config of dir, or of the
+// default docker config directory if dir is empty, so that `docker pull`
+// can use them.  Configured credential helpers are used.
+func WriteDockerConfig(dir string, creds ...Credentials) error {
+	cfg, err := config.Load(dir)
+	if err != nil {
+		return err
+	}
+	for _, c := range creds {
+		if err := cfg.GetCredentialsStore(c.Host).Store(c.authConfig()); err != nil {
+			return err
+		}
+	}
+	return cfg.Save()
+}
+
+func (c Credentials) authConfig() types.AuthConfig {
+	return types.AuthConfig{ServerAddress: c.Host, Username: c.Username, Password: c.Password}
+}
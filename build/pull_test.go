@@ -0,0 +1,69 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/depot/depot-go/depottest"
+)
+
+func TestPullCredentials(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	state := server.AddBuild(depottest.Build{ProjectID: "project"})
+	b, err := FromExistingBuild(ctx, state.ID, state.Token, WithClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := b.PullCredentials(ctx)
+	if err != nil {
+		t.Fatalf("PullCredentials() error = %v", err)
+	}
+	if creds != (Credentials{Host: Registry, Username: "x-token", Password: "pull-" + b.ID}) {
+		t.Errorf("PullCredentials() = %+v", creds)
+	}
+
+	projectCreds, err := ProjectPullCredentials(ctx, "project", "", WithClient(server.Client()))
+	if err != nil {
+		t.Fatalf("ProjectPullCredentials() error = %v", err)
+	}
+	if projectCreds.Password != "pull-project" {
+		t.Errorf("ProjectPullCredentials() = %+v", projectCreds)
+	}
+
+	if got := PullImage("project", b.ID); got != "registry.depot.dev/project:"+b.ID {
+		t.Errorf("PullImage() = %q", got)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths":{"ghcr.io":{"auth":"dXNlcjpwYXNz"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDockerConfig(dir, creds); err != nil {
+		t.Fatalf("WriteDockerConfig() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		Auths map[string]struct{ Auth string } `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cfg.Auths[Registry].Auth, base64.StdEncoding.EncodeToString([]byte("x-token:pull-"+b.ID)); got != want {
+		t.Errorf("config auth = %q, want %q", got, want)
+	}
+	if _, ok := cfg.Auths["ghcr.io"]; !ok {
+		t.Errorf("existing credentials were removed: %s", data)
+	}
+}
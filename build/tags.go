@@ -0,0 +1,81 @@
+package build
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+)
+
+// Tag is an additional tag of the image returned when the build is created.
+type Tag struct {
+	Name string
+	// Push pushes the image with the tag, e.g. to the Depot registry.
+	Push bool
+}
+
+// AuthProvider returns a buildkit session attachable serving the additional
+// credentials of the build.
+func (b *Build) AuthProvider() session.Attachable {
+	return AuthProvider(b.AdditionalCredentials...)
+}
+
+// WithAdditionalTags returns a copy of exports with the additional tags of
+// the build added to the names of the image exporters.  Tags to push are only
+// added to image exporters that push and the other tags only to image
+// exporters that do not, so no tag is pushed unless the API asked for it.  An
+// image exporter is added for tags that have no matching exporter.
+func (b *Build) WithAdditionalTags(exports []client.ExportEntry) []client.ExportEntry {
+	var tags, pushTags []string
+	for _, tag := range b.AdditionalTags {
+		if tag.Push {
+			pushTags = append(pushTags, tag.Name)
+		} else {
+			tags = append(tags, tag.Name)
+		}
+	}
+
+	merged := make([]client.ExportEntry, 0, len(exports)+2)
+	tagged, pushed := false, false
+	for _, export := range exports {
+		if export.Type == client.ExporterImage {
+			export.Attrs = maps.Clone(export.Attrs)
+			if export.Attrs == nil {
+				export.Attrs = map[string]string{}
+			}
+			if export.Attrs["push"] == "true" {
+				export.Attrs["name"] = joinNames(export.Attrs["name"], pushTags)
+				pushed = true
+			} else {
+				export.Attrs["name"] = joinNames(export.Attrs["name"], tags)
+				tagged = true
+			}
+		}
+		merged = append(merged, export)
+	}
+
+	if !tagged && len(tags) > 0 {
+		merged = append(merged, client.ExportEntry{
+			Type:  client.ExporterImage,
+			Attrs: map[string]string{"name": strings.Join(tags, ","), "oci-mediatypes": "true"},
+		})
+	}
+	if !pushed && len(pushTags) > 0 {
+		merged = append(merged, client.ExportEntry{
+			Type:  client.ExporterImage,
+			Attrs: map[string]string{"name": strings.Join(pushTags, ","), "push": "true", "oci-mediatypes": "true"},
+		})
+	}
+	return merged
+}
+
+func joinNames(name string, names []string) string {
+	if len(names) == 0 {
+		return name
+	}
+	if name == "" {
+		return strings.Join(names, ",")
+	}
+	return name + "," + strings.Join(names, ",")
+}
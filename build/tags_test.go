@@ -0,0 +1,84 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/depot/depot-go/depottest"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/moby/buildkit/client"
+)
+
+func TestAdditionalTags(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	req := &cliv1.CreateBuildRequest{ProjectId: "project", Options: []*cliv1.BuildOptions{{Save: true}}}
+	b, err := NewBuild(context.Background(), req, "", WithClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := "registry.depot.dev/project:" + b.ID
+	if len(b.AdditionalTags) != 1 || b.AdditionalTags[0] != (Tag{Name: saved, Push: true}) {
+		t.Errorf("AdditionalTags = %+v", b.AdditionalTags)
+	}
+	wantCreds := Credentials{Host: Registry, Username: "x-token", Password: "save-" + b.ID}
+	if len(b.AdditionalCredentials) != 1 || b.AdditionalCredentials[0] != wantCreds {
+		t.Errorf("AdditionalCredentials = %+v", b.AdditionalCredentials)
+	}
+
+	b.AdditionalTags = append(b.AdditionalTags, Tag{Name: "example/app:extra"})
+
+	tests := []struct {
+		name    string
+		exports []client.ExportEntry
+		want    []map[string]string
+	}{
+		{
+			name:    "pushing exporter",
+			exports: []client.ExportEntry{{Type: client.ExporterImage, Attrs: map[string]string{"name": "example/app", "push": "true"}}},
+			want: []map[string]string{
+				{"name": "example/app," + saved, "push": "true"},
+				{"name": "example/app:extra", "push": "", "oci-mediatypes": "true"},
+			},
+		},
+		{
+			name:    "local exporter",
+			exports: []client.ExportEntry{{Type: client.ExporterImage, Attrs: map[string]string{"name": "example/app"}}},
+			want: []map[string]string{
+				{"name": "example/app,example/app:extra", "push": ""},
+				{"name": saved, "push": "true", "oci-mediatypes": "true"},
+			},
+		},
+		{
+			name: "pushing and local exporters",
+			exports: []client.ExportEntry{
+				{Type: client.ExporterImage, Attrs: map[string]string{"name": "example/app", "push": "true"}},
+				{Type: client.ExporterImage, Attrs: map[string]string{"name": "example/app"}},
+			},
+			want: []map[string]string{
+				{"name": "example/app," + saved, "push": "true"},
+				{"name": "example/app,example/app:extra", "push": ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := b.WithAdditionalTags(tt.exports)
+			if len(got) != len(tt.want) {
+				t.Fatalf("WithAdditionalTags() = %+v, want %d exports", got, len(tt.want))
+			}
+			for i := range got {
+				for k, v := range tt.want[i] {
+					if got[i].Attrs[k] != v {
+						t.Errorf("export %d %s = %q, want %q", i, k, got[i].Attrs[k], v)
+					}
+				}
+			}
+			if tt.exports[0].Attrs["name"] != "example/app" {
+				t.Errorf("WithAdditionalTags() modified its argument")
+			}
+		})
+	}
+}
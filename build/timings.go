@@ -0,0 +1,237 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	depotapi "github.com/depot/depot-go/api"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Timings records the steps of a build from the buildkit solve status.
+type Timings struct {
+	mu       sync.Mutex
+	vertices map[digest.Digest]*client.Vertex
+	// open counts the tapped channels not yet closed; idle is closed when
+	// it drops to zero.
+	open int
+	idle chan struct{}
+}
+
+// NewTimings returns an empty timings recorder.
+func NewTimings() *Timings {
+	return &Timings{vertices: map[digest.Digest]*client.Vertex{}}
+}
+
+// Tap returns a status channel to pass to Solve or Build.  Every status is
+// recorded and forwarded to next, which is closed once the returned channel
+// is closed.  next may be nil.
+func (t *Timings) Tap(next chan *client.SolveStatus) chan *client.SolveStatus {
+	ch := make(chan *client.SolveStatus)
+	t.mu.Lock()
+	t.open++
+	t.mu.Unlock()
+	go func() {
+		defer t.tapClosed()
+		if next != nil {
+			defer close(next)
+		}
+		for status := range ch {
+			t.record(status)
+			if next != nil {
+				next <- status
+			}
+		}
+	}()
+	return ch
+}
+
+func (t *Timings) tapClosed() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.open--
+	if t.open == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// Wait waits until every tapped channel is closed and its statuses recorded,
+// which happens soon after Solve returns.  It returns the error of ctx if
+// ctx ends first, e.g. because a tapped channel was never passed to Solve.
+func (t *Timings) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.open == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Timings) record(status *client.SolveStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, v := range status.Vertexes {
+		prev, ok := t.vertices[v.Digest]
+		if !ok {
+			vertex := *v
+			t.vertices[v.Digest] = &vertex
+			continue
+		}
+		// Updates of a vertex may omit fields set earlier.
+		if v.Name != "" {
+			prev.Name = v.Name
+		}
+		if len(v.Inputs) > 0 {
+			prev.Inputs = v.Inputs
+		}
+		if v.Started != nil && (prev.Started == nil || v.Started.Before(*prev.Started)) {
+			prev.Started = v.Started
+		}
+		if v.Completed != nil && (prev.Completed == nil || v.Completed.After(*prev.Completed)) {
+			prev.Completed = v.Completed
+		}
+		prev.Cached = prev.Cached || v.Cached
+		if v.Error != "" {
+			prev.Error = v.Error
+		}
+	}
+}
+
+// name returns the name of the vertex dgst, if it was recorded.
+func (t *Timings) name(dgst digest.Digest) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if v, ok := t.vertices[dgst]; ok {
+		return v.Name
+	}
+	return ""
+}
+
+// Steps returns the steps started so far ordered by start time.  Call Wait
+// first to include the statuses still being recorded when Solve returns.
+//
+// The digests of buildkit vertices change between builds of the same
+// Dockerfile, so steps are identified by a stable digest of their name and
+// the stable digests of their inputs.
+func (t *Timings) Steps() []*cliv1.BuildStep {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stable := map[digest.Digest]string{}
+	var stableDigest func(dgst digest.Digest) string
+	stableDigest = func(dgst digest.Digest) string {
+		if s, ok := stable[dgst]; ok {
+			return s
+		}
+		v, ok := t.vertices[dgst]
+		if !ok {
+			return dgst.String()
+		}
+		h := sha256.New()
+		h.Write([]byte(v.Name))
+		for _, input := range sortedStableDigests(v.Inputs, stableDigest) {
+			h.Write([]byte{0})
+			h.Write([]byte(input))
+		}
+		s := "sha256:" + hex.EncodeToString(h.Sum(nil))
+		stable[dgst] = s
+		return s
+	}
+
+	var ancestors func(dgst digest.Digest, seen map[string]struct{})
+	ancestors = func(dgst digest.Digest, seen map[string]struct{}) {
+		v, ok := t.vertices[dgst]
+		if !ok {
+			return
+		}
+		for _, input := range v.Inputs {
+			s := stableDigest(input)
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			seen[s] = struct{}{}
+			ancestors(input, seen)
+		}
+	}
+
+	var steps []*cliv1.BuildStep
+	for dgst, v := range t.vertices {
+		if v.Started == nil {
+			continue
+		}
+
+		completed := time.Now()
+		if v.Completed != nil {
+			completed = *v.Completed
+		}
+		step := &cliv1.BuildStep{
+			StartTime:    timestamppb.New(*v.Started),
+			DurationMs:   int32(completed.Sub(*v.Started).Milliseconds()),
+			Name:         v.Name,
+			Cached:       v.Cached,
+			InputDigests: sortedStableDigests(v.Inputs, stableDigest),
+		}
+		s := stableDigest(dgst)
+		step.StableDigest = &s
+		if v.Error != "" {
+			step.Error = &v.Error
+		}
+
+		seen := map[string]struct{}{}
+		ancestors(dgst, seen)
+		for ancestor := range seen {
+			step.AncestorDigests = append(step.AncestorDigests, ancestor)
+		}
+		sort.Strings(step.AncestorDigests)
+
+		steps = append(steps, step)
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool {
+		a, b := steps[i].StartTime.AsTime(), steps[j].StartTime.AsTime()
+		if a.Equal(b) {
+			return steps[i].GetStableDigest() < steps[j].GetStableDigest()
+		}
+		return a.Before(b)
+	})
+	return steps
+}
+
+func sortedStableDigests(inputs []digest.Digest, stableDigest func(digest.Digest) string) []string {
+	digests := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		digests = append(digests, stableDigest(input))
+	}
+	sort.Strings(digests)
+	return digests
+}
+
+// ReportTimings sends the steps of the build to the Depot API.
+func (b *Build) ReportTimings(ctx context.Context, steps []*cliv1.BuildStep) error {
+	req := &cliv1.ReportTimingsRequest{BuildId: b.ID, BuildSteps: steps}
+	_, err := b.client.BuildService().ReportTimings(ctx, depotapi.WithAuthentication(connect.NewRequest(req), b.Token))
+	return err
+}
@@ -0,0 +1,125 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/depot/depot-go/depottest"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTimings(t *testing.T) {
+	start := time.Now().Truncate(time.Millisecond)
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(d)
+		return &t
+	}
+
+	solve := func(base, run digest.Digest) []*client.SolveStatus {
+		return []*client.SolveStatus{
+			{Vertexes: []*client.Vertex{
+				{Digest: base, Name: "[1/2] FROM alpine", Started: at(0)},
+				{Digest: run, Name: "[2/2] RUN make", Inputs: []digest.Digest{base}},
+			}},
+			{Vertexes: []*client.Vertex{
+				{Digest: base, Started: at(0), Completed: at(10 * time.Millisecond), Cached: true},
+				{Digest: run, Started: at(10 * time.Millisecond)},
+			}},
+			{Vertexes: []*client.Vertex{
+				{Digest: run, Started: at(10 * time.Millisecond), Completed: at(40 * time.Millisecond), Error: "exit code: 2"},
+			}},
+		}
+	}
+
+	record := func(statuses []*client.SolveStatus) []*cliv1.BuildStep {
+		timings := NewTimings()
+		forwarded := make(chan *client.SolveStatus)
+		ch := timings.Tap(forwarded)
+		go func() {
+			for _, status := range statuses {
+				ch <- status
+			}
+			close(ch)
+		}()
+		n := 0
+		for range forwarded {
+			n++
+		}
+		if n != len(statuses) {
+			t.Errorf("forwarded %d statuses, want %d", n, len(statuses))
+		}
+		return timings.Steps()
+	}
+
+	steps := record(solve(digest.FromString("base-1"), digest.FromString("run-1")))
+	if len(steps) != 2 {
+		t.Fatalf("Steps() = %d steps, want 2", len(steps))
+	}
+	base, run := steps[0], steps[1]
+	if base.Name != "[1/2] FROM alpine" || !base.Cached || base.DurationMs != 10 || base.Error != nil {
+		t.Errorf("base step = %v", base)
+	}
+	if run.Name != "[2/2] RUN make" || run.Cached || run.DurationMs != 30 || run.GetError() != "exit code: 2" {
+		t.Errorf("run step = %v", run)
+	}
+	if !run.StartTime.AsTime().Equal(*at(10 * time.Millisecond)) {
+		t.Errorf("run start = %v, want %v", run.StartTime.AsTime(), at(10*time.Millisecond))
+	}
+	if len(run.InputDigests) != 1 || run.InputDigests[0] != base.GetStableDigest() {
+		t.Errorf("run inputs = %v, want [%s]", run.InputDigests, base.GetStableDigest())
+	}
+	if len(run.AncestorDigests) != 1 || run.AncestorDigests[0] != base.GetStableDigest() {
+		t.Errorf("run ancestors = %v, want [%s]", run.AncestorDigests, base.GetStableDigest())
+	}
+
+	again := record(solve(digest.FromString("base-2"), digest.FromString("run-2")))
+	if again[0].GetStableDigest() != base.GetStableDigest() || again[1].GetStableDigest() != run.GetStableDigest() {
+		t.Errorf("stable digests changed between builds")
+	}
+
+	server := depottest.NewServer()
+	defer server.Close()
+	state := server.AddBuild(depottest.Build{ProjectID: "project"})
+	b, err := FromExistingBuild(context.Background(), state.ID, state.Token, WithClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ReportTimings(context.Background(), steps); err != nil {
+		t.Fatalf("ReportTimings() error = %v", err)
+	}
+	if state, _ := server.Build(b.ID); len(state.Steps) != 2 {
+		t.Errorf("reported %d steps, want 2", len(state.Steps))
+	}
+}
+
+func TestTimingsWait(t *testing.T) {
+	timings := NewTimings()
+	ch := timings.Tap(nil)
+	started := time.Now()
+	go func() {
+		ch <- &client.SolveStatus{Vertexes: []*client.Vertex{{Digest: digest.FromString("run"), Name: "RUN make", Started: &started}}}
+		ch <- &client.SolveStatus{Vertexes: []*client.Vertex{{Digest: digest.FromString("run"), Started: &started, Completed: &started, Error: "exit code: 1"}}}
+		close(ch)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := timings.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if steps := timings.Steps(); len(steps) != 1 || steps[0].GetError() != "exit code: 1" {
+		t.Errorf("Steps() = %v, want the completed step", steps)
+	}
+
+	// A tap never passed to Solve is never closed.
+	timings.Tap(nil)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := timings.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+}
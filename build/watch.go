@@ -0,0 +1,98 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"time"
+
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+const defaultWatchInterval = 5 * time.Second
+
+// ErrBuildNotFound is returned when a watched build is not in its project.
+var ErrBuildNotFound = errors.New("build not found")
+
+// IsTerminal reports whether a build with status has ended.
+func IsTerminal(status cliv1.BuildStatus) bool {
+	switch status {
+	case cliv1.BuildStatus_BUILD_STATUS_FINISHED,
+		cliv1.BuildStatus_BUILD_STATUS_FAILED,
+		cliv1.BuildStatus_BUILD_STATUS_CANCELED:
+		return true
+	}
+	return false
+}
+
+// WatchBuild polls the build buildID of projectID every interval, five
+// seconds if zero, and yields the build each time its status changes.  The
+// sequence ends after the build reaches a terminal status, or with an error
+// if it cannot be found or ctx is canceled.  token and opts configure the
+// ListBuilds requests as for List; status and creation time filters are
+// ignored as they would hide the build.
+func WatchBuild(ctx context.Context, projectID, buildID, token string, interval time.Duration, opts ...ListOption) iter.Seq2[*cliv1.Build, error] {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	o := newListOptions(opts...)
+	o.statuses, o.createdAfter, o.createdBefore = nil, time.Time{}, time.Time{}
+
+	return func(yield func(*cliv1.Build, error) bool) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := cliv1.BuildStatus_BUILD_STATUS_UNSPECIFIED
+		for {
+			b, err := o.findBuild(ctx, projectID, buildID, token)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			// Later polls stop paging at builds older than this one.
+			o.createdAfter = b.CreatedAt.AsTime()
+			if b.Status != last {
+				last = b.Status
+				if !yield(b, nil) {
+					return
+				}
+			}
+			if IsTerminal(b.Status) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// WaitForBuild blocks until the build buildID of projectID reaches a terminal
+// status and returns it.  See WatchBuild.
+func WaitForBuild(ctx context.Context, projectID, buildID, token string, interval time.Duration, opts ...ListOption) (*cliv1.Build, error) {
+	var last *cliv1.Build
+	for b, err := range WatchBuild(ctx, projectID, buildID, token, interval, opts...) {
+		if err != nil {
+			return nil, err
+		}
+		last = b
+	}
+	return last, nil
+}
+
+func (o *listOptions) findBuild(ctx context.Context, projectID, buildID, token string) (*cliv1.Build, error) {
+	for b, err := range o.list(ctx, projectID, token) {
+		if err != nil {
+			return nil, err
+		}
+		if b.Id == buildID {
+			return b, nil
+		}
+	}
+	return nil, ErrBuildNotFound
+}
@@ -0,0 +1,54 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/depot/depot-go/depottest"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+func TestWaitForBuild(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	state := server.AddBuild(depottest.Build{ProjectID: "project"})
+	b, err := FromExistingBuild(ctx, state.ID, state.Token, WithClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { _ = b.Finish(ctx, errors.New("boom")) })
+
+	// A status filter would hide the running build and is ignored.
+	var seen []cliv1.BuildStatus
+	for got, err := range WatchBuild(ctx, "project", b.ID, "", 10*time.Millisecond, WithClient(server.Client()), WithStatus(cliv1.BuildStatus_BUILD_STATUS_FINISHED)) {
+		if err != nil {
+			t.Fatalf("WatchBuild() error = %v", err)
+		}
+		seen = append(seen, got.Status)
+	}
+	want := []cliv1.BuildStatus{cliv1.BuildStatus_BUILD_STATUS_RUNNING, cliv1.BuildStatus_BUILD_STATUS_FAILED}
+	if len(seen) != len(want) || seen[0] != want[0] || seen[1] != want[1] {
+		t.Errorf("WatchBuild() statuses = %v, want %v", seen, want)
+	}
+
+	got, err := WaitForBuild(ctx, "project", b.ID, "", 10*time.Millisecond, WithClient(server.Client()))
+	if err != nil || got.Status != cliv1.BuildStatus_BUILD_STATUS_FAILED {
+		t.Errorf("WaitForBuild() = %v, %v, want the failed build", got, err)
+	}
+
+	if _, err := WaitForBuild(ctx, "project", "missing", "", time.Millisecond, WithClient(server.Client())); !errors.Is(err, ErrBuildNotFound) {
+		t.Errorf("WaitForBuild() error = %v, want ErrBuildNotFound", err)
+	}
+
+	running := server.AddBuild(depottest.Build{ProjectID: "project"})
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := WaitForBuild(ctx, "project", running.ID, "", 10*time.Millisecond, WithClient(server.Client())); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForBuild() error = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,54 @@
+// Package depot is the entry point to the Depot Go SDK.
+//
+// A Client holds the API configuration and is passed to the build and machine
+// packages with their WithClient options.
+package depot
+
+import (
+	"log/slog"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/api"
+	"github.com/depot/depot-go/auth"
+)
+
+// Client holds the configuration used to talk to the Depot API.
+type Client = api.Client
+
+type ClientOption = api.ClientOption
+
+// NewClient returns a Client configured with opts.
+func NewClient(opts ...ClientOption) *Client {
+	return api.NewClient(opts...)
+}
+
+// WithBaseURL sets the Depot API URL.  Defaults to DEPOT_API_URL or https://api.depot.dev.
+func WithBaseURL(baseURL string) ClientOption {
+	return api.WithBaseURL(baseURL)
+}
+
+// WithHTTPClient sets the HTTP client used for API requests.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return api.WithHTTPClient(httpClient)
+}
+
+// WithConnectOptions appends connect client options to every API client.
+func WithConnectOptions(opts ...connect.ClientOption) ClientOption {
+	return api.WithConnectOptions(opts...)
+}
+
+// WithTokenSource sets where the API token comes from when a call is not given one.
+func WithTokenSource(tokenSource auth.TokenSource) ClientOption {
+	return api.WithTokenSource(tokenSource)
+}
+
+// WithToken uses a fixed API token when a call is not given one.
+func WithToken(token string) ClientOption {
+	return api.WithToken(token)
+}
+
+// WithLogger sets the logger used by the client.
+func WithLogger(l *slog.Logger) ClientOption {
+	return api.WithLogger(l)
+}
@@ -0,0 +1,97 @@
+package depottest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"google.golang.org/grpc"
+)
+
+// Buildkitd is a fake buildkitd serving enough of the buildkit control API
+// for machine.Connect, e.g. the endpoint of an Active connection:
+//
+//	buildkitd := depottest.NewBuildkitd()
+//	defer buildkitd.Close()
+//	server.SetConnection(depottest.Active(buildkitd.Addr))
+type Buildkitd struct {
+	// Addr is the buildkit host of the fake, e.g. tcp://127.0.0.1:1234.
+	Addr string
+
+	server *grpc.Server
+
+	mu        sync.Mutex
+	block     bool
+	encodings []string
+}
+
+// NewBuildkitd starts a fake buildkitd on a local port.  opts configure the
+// gRPC server, e.g. grpc.Creds for mTLS.  Close must be called when done.
+func NewBuildkitd(opts ...grpc.ServerOption) *Buildkitd {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("depottest: failed to listen on a port: %v", err))
+	}
+
+	b := &Buildkitd{Addr: "tcp://" + lis.Addr().String()}
+	opts = append(opts, grpc.ChainUnaryInterceptor(b.interceptor))
+	b.server = grpc.NewServer(opts...)
+	controlapi.RegisterControlServer(b.server, &controlServer{b: b})
+	go func() { _ = b.server.Serve(lis) }()
+	return b
+}
+
+// Close stops the fake buildkitd.
+func (b *Buildkitd) Close() {
+	b.server.Stop()
+}
+
+// BlockListWorkers makes ListWorkers wait until the request is canceled,
+// e.g. to test how a build ends when its context does.
+func (b *Buildkitd) BlockListWorkers(block bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.block = block
+}
+
+// Encodings returns the grpc-encoding of every request received, "" for
+// uncompressed requests.
+func (b *Buildkitd) Encodings() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string(nil), b.encodings...)
+}
+
+func (b *Buildkitd) interceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var encoding string
+	if stream, ok := grpc.ServerTransportStreamFromContext(ctx).(interface{ RecvCompress() string }); ok {
+		encoding = stream.RecvCompress()
+	}
+
+	b.mu.Lock()
+	b.encodings = append(b.encodings, encoding)
+	b.mu.Unlock()
+
+	return handler(ctx, req)
+}
+
+type controlServer struct {
+	controlapi.UnimplementedControlServer
+	b *Buildkitd
+}
+
+func (s *controlServer) ListWorkers(ctx context.Context, req *controlapi.ListWorkersRequest) (*controlapi.ListWorkersResponse, error) {
+	s.b.mu.Lock()
+	block := s.b.block
+	s.b.mu.Unlock()
+
+	if block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &controlapi.ListWorkersResponse{}, nil
+}
@@ -0,0 +1,233 @@
+// Package depottest provides an in-process fake of the Depot BuildService API
+// for tests.
+//
+// The fake keeps builds in memory, records every call it receives and can be
+// told to fail or slow down individual RPCs:
+//
+//	server := depottest.NewServer()
+//	defer server.Close()
+//
+//	server.ScriptConnection(depottest.Pending(10*time.Millisecond), depottest.Active("tcp://127.0.0.1:1234"))
+//	b, err := build.NewBuild(ctx, req, "", build.WithClient(server.Client()))
+package depottest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/api"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
+	"google.golang.org/protobuf/proto"
+)
+
+// Server is a fake BuildService served over an httptest.Server.
+type Server struct {
+	// URL is the base URL of the fake API.
+	URL string
+
+	server *httptest.Server
+
+	mu          sync.Mutex
+	builds      map[string]*Build
+	order       []string
+	nextID      int
+	calls       []Call
+	errors      map[string]error
+	nextErrors  map[string][]error
+	latencies   map[string]time.Duration
+	connections []*cliv1.GetBuildKitConnectionResponse
+	connection  *cliv1.GetBuildKitConnectionResponse
+	profiling   bool
+}
+
+// Call is a request received by the Server.
+type Call struct {
+	// Procedure is the full RPC name, e.g. cliv1connect.BuildServiceCreateBuildProcedure.
+	Procedure string
+	Header    http.Header
+	Request   proto.Message
+	// Err is the error returned to the client, if any.
+	Err  error
+	Time time.Time
+}
+
+// Token returns the bearer token sent with the call.
+func (c Call) Token() string {
+	return token(c.Header.Get("Authorization"))
+}
+
+// NewServer starts a fake BuildService.  Close must be called when done.
+func NewServer() *Server {
+	s := &Server{
+		builds:     map[string]*Build{},
+		errors:     map[string]error{},
+		nextErrors: map[string][]error{},
+		latencies:  map[string]time.Duration{},
+	}
+
+	path, handler := cliv1connect.NewBuildServiceHandler(&service{s}, connect.WithInterceptors(s.interceptor()))
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns an API client for the server.  It authenticates with the
+// token "depottest" unless opts set another token source.
+func (s *Server) Client(opts ...api.ClientOption) *api.Client {
+	opts = append([]api.ClientOption{
+		api.WithBaseURL(s.URL),
+		api.WithHTTPClient(s.server.Client()),
+		api.WithToken("depottest"),
+	}, opts...)
+	return api.NewClient(opts...)
+}
+
+// Calls returns the calls received for procedure, or every call if procedure is empty.
+func (s *Server) Calls(procedure string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var calls []Call
+	for _, call := range s.calls {
+		if procedure == "" || call.Procedure == procedure {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// SetError makes every call to procedure fail with err until it is cleared with a nil error.
+func (s *Server) SetError(procedure string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		delete(s.errors, procedure)
+		return
+	}
+	s.errors[procedure] = err
+}
+
+// FailNext makes the next len(errs) calls to procedure fail with errs in order.
+func (s *Server) FailNext(procedure string, errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextErrors[procedure] = append(s.nextErrors[procedure], errs...)
+}
+
+// SetLatency delays every call to procedure by d.
+func (s *Server) SetLatency(procedure string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies[procedure] = d
+}
+
+// ScriptConnection queues responses for GetBuildKitConnection.  Each call
+// consumes one response; once the queue is empty the response set by
+// SetConnection is returned.
+func (s *Server) ScriptConnection(responses ...*cliv1.GetBuildKitConnectionResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connections = append(s.connections, responses...)
+}
+
+// SetConnection sets the GetBuildKitConnection response returned when no
+// scripted responses are left.  Without one the call fails as unavailable.
+func (s *Server) SetConnection(response *cliv1.GetBuildKitConnectionResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connection = response
+}
+
+// SetProfiling makes CreateBuild return a profiler token for new builds.
+func (s *Server) SetProfiling(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profiling = enabled
+}
+
+// Pending returns a GetBuildKitConnection response asking the client to retry after wait.
+func Pending(wait time.Duration) *cliv1.GetBuildKitConnectionResponse {
+	return &cliv1.GetBuildKitConnectionResponse{
+		Connection: &cliv1.GetBuildKitConnectionResponse_Pending{
+			Pending: &cliv1.GetBuildKitConnectionResponse_PendingConnection{WaitMs: int32(wait.Milliseconds())},
+		},
+	}
+}
+
+// Active returns a GetBuildKitConnection response pointing at a buildkitd
+// listening on endpoint without TLS.
+func Active(endpoint string) *cliv1.GetBuildKitConnectionResponse {
+	return &cliv1.GetBuildKitConnectionResponse{
+		Connection: &cliv1.GetBuildKitConnectionResponse_Active{
+			Active: &cliv1.GetBuildKitConnectionResponse_ActiveConnection{
+				Endpoint: endpoint,
+				Cert:     &cliv1.Cert{},
+				CaCert:   &cliv1.Cert{},
+			},
+		},
+	}
+}
+
+func (s *Server) interceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			procedure := req.Spec().Procedure
+
+			s.mu.Lock()
+			latency := s.latencies[procedure]
+			err := s.errors[procedure]
+			if queued := s.nextErrors[procedure]; len(queued) > 0 {
+				err = queued[0]
+				s.nextErrors[procedure] = queued[1:]
+			}
+			s.mu.Unlock()
+
+			if latency > 0 {
+				select {
+				case <-time.After(latency):
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			}
+
+			var res connect.AnyResponse
+			if err == nil {
+				res, err = next(ctx, req)
+			}
+
+			call := Call{
+				Procedure: procedure,
+				Header:    req.Header().Clone(),
+				Err:       err,
+				Time:      time.Now(),
+			}
+			if msg, ok := req.Any().(proto.Message); ok {
+				call.Request = proto.Clone(msg)
+			}
+
+			s.mu.Lock()
+			s.calls = append(s.calls, call)
+			s.mu.Unlock()
+
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package depottest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/api"
+	"github.com/depot/depot-go/build"
+	"github.com/depot/depot-go/machine"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
+)
+
+func TestServerBuildLifecycle(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	client := server.Client()
+
+	b, err := build.NewBuild(ctx, &cliv1.CreateBuildRequest{ProjectId: "project"}, "", build.WithClient(client))
+	if err != nil {
+		t.Fatalf("NewBuild() error = %v", err)
+	}
+
+	server.ScriptConnection(Pending(time.Millisecond), Active("tcp://127.0.0.1:1234"))
+	m, err := machine.Acquire(ctx, b.ID, b.Token, "amd64", machine.WithClient(client))
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if m.Addr != "tcp://127.0.0.1:1234" {
+		t.Errorf("Addr = %q, want %q", m.Addr, "tcp://127.0.0.1:1234")
+	}
+	_ = m.Release()
+
+	if err := b.Finish(ctx, errors.New("boom")); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+	if err := b.Finish(ctx, nil); err != nil {
+		t.Errorf("second Finish() error = %v", err)
+	}
+
+	state, ok := server.Build(b.ID)
+	if !ok {
+		t.Fatalf("Build(%q) not found", b.ID)
+	}
+	if state.Status != cliv1.BuildStatus_BUILD_STATUS_FAILED || state.Error != "boom" {
+		t.Errorf("build status = %v %q, want failed with boom", state.Status, state.Error)
+	}
+
+	if got := len(server.Calls(cliv1connect.BuildServiceGetBuildKitConnectionProcedure)); got != 2 {
+		t.Errorf("GetBuildKitConnection calls = %d, want 2", got)
+	}
+	finish := server.Calls(cliv1connect.BuildServiceFinishBuildProcedure)
+	if len(finish) != 1 || finish[0].Token() != b.Token {
+		t.Errorf("FinishBuild calls = %+v, want one with the build token", finish)
+	}
+}
+
+func TestServerListBuildsPaging(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		server.AddBuild(Build{ProjectID: "project", CreatedAt: start.Add(time.Duration(i) * time.Second)})
+	}
+	server.AddBuild(Build{ProjectID: "other"})
+
+	client := server.Client().BuildService()
+	var ids []string
+	pageToken := ""
+	for {
+		req := connect.NewRequest(&cliv1.ListBuildsRequest{ProjectId: "project", PageSize: 2, PageToken: pageToken})
+		res, err := client.ListBuilds(context.Background(), api.WithAuthentication(req, "depottest"))
+		if err != nil {
+			t.Fatalf("ListBuilds() error = %v", err)
+		}
+		for _, b := range res.Msg.Builds {
+			ids = append(ids, b.Id)
+		}
+		pageToken = res.Msg.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	want := []string{"build-5", "build-4", "build-3", "build-2", "build-1"}
+	if len(ids) != len(want) {
+		t.Fatalf("ListBuilds() = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ListBuilds() = %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestServerInjectedErrors(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	server.FailNext(cliv1connect.BuildServiceCreateBuildProcedure, connect.NewError(connect.CodeUnavailable, errors.New("down")))
+	server.SetLatency(cliv1connect.BuildServiceCreateBuildProcedure, 10*time.Millisecond)
+
+	ctx := context.Background()
+	req := &cliv1.CreateBuildRequest{ProjectId: "project"}
+	_, err := build.NewBuild(ctx, req, "", build.WithClient(server.Client()))
+	if connect.CodeOf(err) != connect.CodeUnavailable {
+		t.Fatalf("NewBuild() error = %v, want unavailable", err)
+	}
+
+	start := time.Now()
+	if _, err := build.NewBuild(ctx, req, "", build.WithClient(server.Client())); err != nil {
+		t.Fatalf("NewBuild() error = %v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Errorf("NewBuild() returned before the injected latency")
+	}
+
+	calls := server.Calls(cliv1connect.BuildServiceCreateBuildProcedure)
+	if len(calls) != 2 || calls[0].Err == nil || calls[1].Err != nil {
+		t.Errorf("CreateBuild calls = %+v, want a failed then a successful call", calls)
+	}
+}
+
+func TestServerLatencyCanceled(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	server.SetLatency(cliv1connect.BuildServiceCreateBuildProcedure, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req := &cliv1.CreateBuildRequest{ProjectId: "project"}
+	if _, err := build.NewBuild(ctx, req, "", build.WithClient(server.Client())); err == nil {
+		t.Fatal("NewBuild() succeeded before the injected latency")
+	}
+
+	// The server sees the cancellation after the client gives up.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Calls(cliv1connect.BuildServiceCreateBuildProcedure)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	calls := server.Calls(cliv1connect.BuildServiceCreateBuildProcedure)
+	if len(calls) != 1 || calls[0].Err == nil {
+		t.Errorf("CreateBuild calls = %+v, want one canceled call", calls)
+	}
+}
@@ -0,0 +1,328 @@
+package depottest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultPageSize = 10
+
+// Build is the state the Server keeps for a build.
+type Build struct {
+	ID        string
+	Token     string
+	ProjectID string
+	Status    cliv1.BuildStatus
+	// Error is the error reported by FinishBuild.
+	Error      string
+	CreatedAt  time.Time
+	FinishedAt time.Time
+	// CancelsAt is returned from ReportBuildHealth when set.
+	CancelsAt time.Time
+
+	Options     []*cliv1.BuildOptions
+	Health      []cliv1.BuilderPlatform
+	Steps       []*cliv1.BuildStep
+	Dockerfiles []*cliv1.Dockerfile
+}
+
+// AddBuild stores b as if it had been created through CreateBuild.  An ID,
+// token, status and creation time are filled in when missing.
+func (s *Server) AddBuild(b Build) Build {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addBuild(b)
+}
+
+func (s *Server) addBuild(b Build) Build {
+	s.nextID++
+	if b.ID == "" {
+		b.ID = "build-" + strconv.Itoa(s.nextID)
+	}
+	if b.Token == "" {
+		b.Token = "token-" + strconv.Itoa(s.nextID)
+	}
+	if b.Status == cliv1.BuildStatus_BUILD_STATUS_UNSPECIFIED {
+		b.Status = cliv1.BuildStatus_BUILD_STATUS_RUNNING
+	}
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now()
+	}
+
+	stored := b
+	s.builds[b.ID] = &stored
+	s.order = append(s.order, b.ID)
+	return b
+}
+
+// Build returns the state of the build with id.
+func (s *Server) Build(id string) (Build, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.builds[id]
+	if !ok {
+		return Build{}, false
+	}
+	return b.clone(), true
+}
+
+// Builds returns every build in creation order.
+func (s *Server) Builds() []Build {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	builds := make([]Build, 0, len(s.order))
+	for _, id := range s.order {
+		builds = append(builds, s.builds[id].clone())
+	}
+	return builds
+}
+
+// SetCancelsAt sets the cancels_at deadline returned by ReportBuildHealth for a build.
+func (s *Server) SetCancelsAt(buildID string, cancelsAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.builds[buildID]; ok {
+		b.CancelsAt = cancelsAt
+	}
+}
+
+type service struct {
+	s *Server
+}
+
+func (svc *service) CreateBuild(ctx context.Context, req *connect.Request[cliv1.CreateBuildRequest]) (*connect.Response[cliv1.CreateBuildResponse], error) {
+	if token(req.Header().Get("Authorization")) == "" {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("missing token"))
+	}
+	if req.Msg.ProjectId == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("missing project ID"))
+	}
+
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	b := svc.s.addBuild(Build{ProjectID: req.Msg.ProjectId, Options: req.Msg.Options})
+	res := &cliv1.CreateBuildResponse{
+		BuildId:    b.ID,
+		BuildToken: b.Token,
+		BuildUrl:   fmt.Sprintf("%s/builds/%s", svc.s.URL, b.ID),
+		ProjectId:  b.ProjectID,
+		Registry:   &cliv1.Registry{},
+	}
+	if svc.s.profiling {
+		res.Profiler = &cliv1.CreateBuildResponse_Profiler{Token: "profiler-" + b.ID}
+	}
+	// Saved builds are pushed to the Depot registry.
+	if slices.ContainsFunc(req.Msg.Options, func(o *cliv1.BuildOptions) bool { return o.Save }) {
+		res.AdditionalCredentials = []*cliv1.CreateBuildResponse_Credential{{Host: "registry.depot.dev", Token: "save-" + b.ID}}
+		res.AdditionalTags = []*cliv1.CreateBuildResponse_Tag{{Tag: fmt.Sprintf("registry.depot.dev/%s:%s", b.ProjectID, b.ID), Push: true}}
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (svc *service) FinishBuild(ctx context.Context, req *connect.Request[cliv1.FinishBuildRequest]) (*connect.Response[cliv1.FinishBuildResponse], error) {
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	b, err := svc.s.authorizeBuild(req.Header().Get("Authorization"), req.Msg.BuildId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch result := req.Msg.Result.(type) {
+	case *cliv1.FinishBuildRequest_Success:
+		b.Status = cliv1.BuildStatus_BUILD_STATUS_FINISHED
+	case *cliv1.FinishBuildRequest_Error:
+		b.Status = cliv1.BuildStatus_BUILD_STATUS_FAILED
+		b.Error = result.Error.Error
+	case *cliv1.FinishBuildRequest_Canceled:
+		b.Status = cliv1.BuildStatus_BUILD_STATUS_CANCELED
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("missing result"))
+	}
+	b.FinishedAt = time.Now()
+
+	return connect.NewResponse(&cliv1.FinishBuildResponse{}), nil
+}
+
+func (svc *service) GetBuildKitConnection(ctx context.Context, req *connect.Request[cliv1.GetBuildKitConnectionRequest]) (*connect.Response[cliv1.GetBuildKitConnectionResponse], error) {
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	if _, err := svc.s.authorizeBuild(req.Header().Get("Authorization"), req.Msg.BuildId); err != nil {
+		return nil, err
+	}
+
+	response := svc.s.connection
+	if len(svc.s.connections) > 0 {
+		response = svc.s.connections[0]
+		svc.s.connections = svc.s.connections[1:]
+	}
+	if response == nil {
+		return nil, connect.NewError(connect.CodeUnavailable, errors.New("no buildkit connection scripted"))
+	}
+
+	return connect.NewResponse(proto.Clone(response).(*cliv1.GetBuildKitConnectionResponse)), nil
+}
+
+func (svc *service) ReportBuildHealth(ctx context.Context, req *connect.Request[cliv1.ReportBuildHealthRequest]) (*connect.Response[cliv1.ReportBuildHealthResponse], error) {
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	b, err := svc.s.authorizeBuild(req.Header().Get("Authorization"), req.Msg.BuildId)
+	if err != nil {
+		return nil, err
+	}
+	b.Health = append(b.Health, req.Msg.Platform)
+
+	res := &cliv1.ReportBuildHealthResponse{}
+	if !b.CancelsAt.IsZero() {
+		res.CancelsAt = timestamppb.New(b.CancelsAt)
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (svc *service) ReportTimings(ctx context.Context, req *connect.Request[cliv1.ReportTimingsRequest]) (*connect.Response[cliv1.ReportTimingsResponse], error) {
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	b, err := svc.s.authorizeBuild(req.Header().Get("Authorization"), req.Msg.BuildId)
+	if err != nil {
+		return nil, err
+	}
+	b.Steps = append(b.Steps, req.Msg.BuildSteps...)
+
+	return connect.NewResponse(&cliv1.ReportTimingsResponse{}), nil
+}
+
+func (svc *service) ReportBuildContext(ctx context.Context, req *connect.Request[cliv1.ReportBuildContextRequest]) (*connect.Response[cliv1.ReportBuildContextResponse], error) {
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	b, err := svc.s.authorizeBuild(req.Header().Get("Authorization"), req.Msg.BuildId)
+	if err != nil {
+		return nil, err
+	}
+	b.Dockerfiles = append(b.Dockerfiles, req.Msg.Dockerfiles...)
+
+	return connect.NewResponse(&cliv1.ReportBuildContextResponse{}), nil
+}
+
+func (svc *service) ListBuilds(ctx context.Context, req *connect.Request[cliv1.ListBuildsRequest]) (*connect.Response[cliv1.ListBuildsResponse], error) {
+	if token(req.Header().Get("Authorization")) == "" {
+		return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("missing token"))
+	}
+	if req.Msg.ProjectId == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("missing project ID"))
+	}
+
+	offset := 0
+	if req.Msg.PageToken != "" {
+		var err error
+		offset, err = strconv.Atoi(req.Msg.PageToken)
+		if err != nil || offset < 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid page token"))
+		}
+	}
+	pageSize := int(req.Msg.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	var builds []*Build
+	for _, id := range svc.s.order {
+		if b := svc.s.builds[id]; b.ProjectID == req.Msg.ProjectId {
+			builds = append(builds, b)
+		}
+	}
+	// Newest builds first.
+	sort.SliceStable(builds, func(i, j int) bool { return builds[i].CreatedAt.After(builds[j].CreatedAt) })
+
+	res := &cliv1.ListBuildsResponse{}
+	for i := offset; i < len(builds) && i < offset+pageSize; i++ {
+		res.Builds = append(res.Builds, builds[i].proto())
+	}
+	if offset+pageSize < len(builds) {
+		res.NextPageToken = strconv.Itoa(offset + pageSize)
+	}
+
+	return connect.NewResponse(res), nil
+}
+
+func (svc *service) GetPullToken(ctx context.Context, req *connect.Request[cliv1.GetPullTokenRequest]) (*connect.Response[cliv1.GetPullTokenResponse], error) {
+	auth := req.Header().Get("Authorization")
+
+	svc.s.mu.Lock()
+	defer svc.s.mu.Unlock()
+
+	switch {
+	case req.Msg.BuildId != nil:
+		b, err := svc.s.authorizeBuild(auth, req.Msg.GetBuildId())
+		if err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(&cliv1.GetPullTokenResponse{Token: "pull-" + b.ID}), nil
+	case req.Msg.ProjectId != nil:
+		if token(auth) == "" {
+			return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("missing token"))
+		}
+		return connect.NewResponse(&cliv1.GetPullTokenResponse{Token: "pull-" + req.Msg.GetProjectId()}), nil
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("missing project or build ID"))
+	}
+}
+
+// authorizeBuild must be called with the lock held.
+func (s *Server) authorizeBuild(authorization, buildID string) (*Build, error) {
+	b, ok := s.builds[buildID]
+	if !ok {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("build %s not found", buildID))
+	}
+	if token(authorization) != b.Token {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("invalid token for build %s", buildID))
+	}
+	return b, nil
+}
+
+func (b *Build) clone() Build {
+	c := *b
+	c.Options = slices.Clone(b.Options)
+	c.Health = slices.Clone(b.Health)
+	c.Steps = slices.Clone(b.Steps)
+	c.Dockerfiles = slices.Clone(b.Dockerfiles)
+	return c
+}
+
+func (b *Build) proto() *cliv1.Build {
+	pb := &cliv1.Build{
+		Id:        b.ID,
+		Status:    b.Status,
+		CreatedAt: timestamppb.New(b.CreatedAt),
+	}
+	if !b.FinishedAt.IsZero() {
+		pb.FinishedAt = timestamppb.New(b.FinishedAt)
+	}
+	return pb
+}
+
+func token(authorization string) string {
+	return strings.TrimPrefix(authorization, "Bearer ")
+}
@@ -5,10 +5,8 @@ import (
 	"encoding/base64"
 	"log"
 	"os"
-	"time"
 
-	"github.com/depot/depot-go/build"
-	"github.com/depot/depot-go/machine"
+	"github.com/depot/depot-go"
 	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
@@ -36,38 +34,17 @@ func main() {
 			},
 		},
 	}
-	build, err := build.NewBuild(ctx, req, token)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Set the buildErr to any error that represents the build failing.
-	var buildErr error
-	defer build.Finish(buildErr)
-
-	// 2. Acquire a buildkit machine.
-	var buildkit *machine.Machine
-	buildkit, buildErr = machine.Acquire(ctx, build.ID, build.Token, "amd64")
-	if buildErr != nil {
-		return
-	}
-	defer buildkit.Release()
-
-	// 3. Check buildkitd readiness. When the buildkitd starts, it may take
-	// quite a while to be ready to accept connections when it loads a large boltdb.
-	connectCtx, cancelConnect := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancelConnect()
 
-	var buildkitClient *client.Client
-	buildkitClient, buildErr = buildkit.Connect(connectCtx)
-	if buildErr != nil {
-		return
-	}
+	// 2. Acquire a buildkit machine and wait for buildkitd to be ready.  When
+	// the buildkitd starts, it may take quite a while to be ready to accept
+	// connections when it loads a large boltdb.
+	opts := depot.RunOptions{Request: req, Token: token, Platform: "amd64"}
 
-	// 4. Use the buildkit client to build the image.
-	buildErr = buildImage(ctx, buildkitClient)
-	if buildErr != nil {
-		return
+	// 3. Use the buildkit client to build the image.  Run reports the result
+	// of the build to Depot and releases the machine.
+	err := depot.Run(ctx, opts, buildImage)
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
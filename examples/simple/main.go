@@ -6,11 +6,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"time"
 
-	"github.com/depot/depot-go/build"
-	"github.com/depot/depot-go/machine"
-	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/depot/depot-go"
 	"github.com/docker/cli/cli/config"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/session"
@@ -30,36 +27,6 @@ func main() {
 	workingDir := "."
 	imageTag := "goller/depot-example:latest"
 
-	// 1. Register a new build.  This returns back an id and a temporary build token.
-	req := &cliv1.CreateBuildRequest{
-		ProjectId: project,
-	}
-	build, err := build.NewBuild(ctx, req, token)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Set the buildErr to any error that represents the build failing.
-	var buildErr error
-	defer build.Finish(buildErr)
-
-	// 2. Acquire a buildkit machine.
-	var buildkit *machine.Machine
-	buildkit, buildErr = machine.Acquire(ctx, build.ID, build.Token, "arm64" /* or "amd64" */)
-	if buildErr != nil {
-		return
-	}
-	defer buildkit.Release()
-
-	connectCtx, cancelConnect := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancelConnect()
-
-	var buildkitClient *client.Client
-	buildkitClient, buildErr = buildkit.Connect(connectCtx)
-	if buildErr != nil {
-		return
-	}
-
 	solverOptions := client.SolveOpt{
 		Frontend: "dockerfile.v0", // Interpret the build as a Dockerfile.
 		FrontendAttrs: map[string]string{
@@ -86,19 +53,25 @@ func main() {
 		},
 	}
 
-	// 3. Print all build status updates as JSON to stdout.
-	buildStatusCh := make(chan *client.SolveStatus, 10)
-	go func() {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		for status := range buildStatusCh {
-			_ = enc.Encode(status)
-		}
-	}()
+	// 1. Register a build, acquire an arm64 buildkit machine and build on it.
+	// Run reports the result of the build to Depot and releases the machine.
+	opts := depot.RunOptions{ProjectID: project, Token: token, Platform: "arm64" /* or "amd64" */}
+	err := depot.Run(ctx, opts, func(ctx context.Context, buildkitClient *client.Client) error {
+		// 2. Print all build status updates as JSON to stdout.
+		buildStatusCh := make(chan *client.SolveStatus, 10)
+		go func() {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			for status := range buildStatusCh {
+				_ = enc.Encode(status)
+			}
+		}()
 
-	// 4. Build and push the image.
-	_, buildErr = buildkitClient.Solve(ctx, nil, solverOptions, buildStatusCh)
-	if buildErr != nil {
-		return
+		// 3. Build and push the image.
+		_, err := buildkitClient.Solve(ctx, nil, solverOptions, buildStatusCh)
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,81 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/depot/depot-go/internal/oidc/common"
+)
+
+// ServiceConnectionEnv names the service connection the ID token is issued
+// for.  AZURESUBSCRIPTION_SERVICE_CONNECTION_ID, set by the Azure CLI task,
+// is used if it is not set.
+const ServiceConnectionEnv = "DEPOT_AZURE_SERVICE_CONNECTION_ID"
+
+type AzurePipelinesOIDCProvider struct {
+}
+
+func NewAzurePipelinesOIDCProvider() *AzurePipelinesOIDCProvider {
+	return &AzurePipelinesOIDCProvider{}
+}
+
+func (p *AzurePipelinesOIDCProvider) Name() string {
+	return "azure-pipelines"
+}
+
+func (p *AzurePipelinesOIDCProvider) RetrieveToken(ctx context.Context) (string, error) {
+	accessToken := os.Getenv("SYSTEM_ACCESSTOKEN")
+	if accessToken == "" {
+		return "", nil
+	}
+
+	requestURI := os.Getenv("SYSTEM_OIDCREQUESTURI")
+	if requestURI == "" {
+		return "", nil
+	}
+
+	serviceConnectionID := os.Getenv(ServiceConnectionEnv)
+	if serviceConnectionID == "" {
+		serviceConnectionID = os.Getenv("AZURESUBSCRIPTION_SERVICE_CONNECTION_ID")
+	}
+	if serviceConnectionID == "" {
+		return "", nil
+	}
+
+	query := url.Values{}
+	query.Set("api-version", "7.1")
+	query.Set("serviceConnectionId", serviceConnectionID)
+	query.Set("audience", common.Audience)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURI+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("azure pipelines OIDC request failed: %s", resp.Status)
+	}
+
+	var payload struct {
+		OIDCToken string `json:"oidcToken"`
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		return "", err
+	}
+	return payload.OIDCToken, nil
+}
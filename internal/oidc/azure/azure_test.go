@@ -0,0 +1,92 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRetrieveToken(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"oidcToken": "id-token"})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "not in Azure Pipelines",
+			env:  map[string]string{},
+		},
+		{
+			name: "no service connection",
+			env:  map[string]string{"SYSTEM_ACCESSTOKEN": "access-token", "SYSTEM_OIDCREQUESTURI": server.URL + "/oidctoken"},
+		},
+		{
+			name: "token",
+			env: map[string]string{
+				"SYSTEM_ACCESSTOKEN":                "access-token",
+				"SYSTEM_OIDCREQUESTURI":             server.URL + "/oidctoken",
+				"DEPOT_AZURE_SERVICE_CONNECTION_ID": "connection",
+			},
+			want: "id-token",
+		},
+		{
+			name: "azure cli service connection",
+			env: map[string]string{
+				"SYSTEM_ACCESSTOKEN":                      "access-token",
+				"SYSTEM_OIDCREQUESTURI":                   server.URL + "/oidctoken",
+				"AZURESUBSCRIPTION_SERVICE_CONNECTION_ID": "connection",
+			},
+			want: "id-token",
+		},
+		{
+			name: "rejected",
+			env: map[string]string{
+				"SYSTEM_ACCESSTOKEN":                "expired",
+				"SYSTEM_OIDCREQUESTURI":             server.URL + "/oidctoken",
+				"DEPOT_AZURE_SERVICE_CONNECTION_ID": "connection",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"SYSTEM_ACCESSTOKEN", "SYSTEM_OIDCREQUESTURI", "DEPOT_AZURE_SERVICE_CONNECTION_ID", "AZURESUBSCRIPTION_SERVICE_CONNECTION_ID"} {
+				t.Setenv(name, "")
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			got = nil
+
+			token, err := NewAzurePipelinesOIDCProvider().RetrieveToken(context.Background())
+			if (err != nil) != tt.wantErr || token != tt.want {
+				t.Fatalf("RetrieveToken() = %q, %v, want %q", token, err, tt.want)
+			}
+			if tt.want == "" {
+				return
+			}
+
+			query := got.URL.Query()
+			if got.Method != http.MethodPost || got.URL.Path != "/oidctoken" {
+				t.Errorf("request = %s %s", got.Method, got.URL.Path)
+			}
+			if query.Get("serviceConnectionId") != "connection" || query.Get("audience") != "https://depot.dev" || query.Get("api-version") == "" {
+				t.Errorf("query = %v", query)
+			}
+		})
+	}
+}
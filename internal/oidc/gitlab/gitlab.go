@@ -0,0 +1,46 @@
+package gitlab
+
+import (
+	"context"
+	"os"
+)
+
+// DefaultVariable is the variable of the ID token when none is configured:
+//
+//	id_tokens:
+//	  DEPOT_OIDC_TOKEN:
+//	    aud: https://depot.dev
+const DefaultVariable = "DEPOT_OIDC_TOKEN"
+
+// VariableEnv names the variable of the ID token when set.
+const VariableEnv = "DEPOT_OIDC_TOKEN_VARIABLE"
+
+type GitLabOIDCProvider struct {
+	variable string
+}
+
+// NewGitLabOIDCProvider returns a provider reading the GitLab ID token from
+// the environment variable named variable.  If variable is empty it is taken
+// from VariableEnv, or DefaultVariable.
+func NewGitLabOIDCProvider(variable string) *GitLabOIDCProvider {
+	return &GitLabOIDCProvider{variable: variable}
+}
+
+func (p *GitLabOIDCProvider) Name() string {
+	return "gitlab"
+}
+
+func (p *GitLabOIDCProvider) RetrieveToken(ctx context.Context) (string, error) {
+	if os.Getenv("GITLAB_CI") == "" {
+		return "", nil
+	}
+
+	variable := p.variable
+	if variable == "" {
+		variable = os.Getenv(VariableEnv)
+	}
+	if variable == "" {
+		variable = DefaultVariable
+	}
+	return os.Getenv(variable), nil
+}
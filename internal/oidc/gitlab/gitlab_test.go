@@ -0,0 +1,51 @@
+package gitlab
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetrieveToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		variable string
+		env      map[string]string
+		want     string
+	}{
+		{
+			name: "not in GitLab",
+			env:  map[string]string{"DEPOT_OIDC_TOKEN": "token"},
+		},
+		{
+			name: "default variable",
+			env:  map[string]string{"GITLAB_CI": "true", "DEPOT_OIDC_TOKEN": "token"},
+			want: "token",
+		},
+		{
+			name: "variable from env",
+			env:  map[string]string{"GITLAB_CI": "true", "DEPOT_OIDC_TOKEN_VARIABLE": "ID_TOKEN", "ID_TOKEN": "env-token"},
+			want: "env-token",
+		},
+		{
+			name:     "configured variable",
+			variable: "MY_TOKEN",
+			env:      map[string]string{"GITLAB_CI": "true", "DEPOT_OIDC_TOKEN": "token", "MY_TOKEN": "my-token"},
+			want:     "my-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"GITLAB_CI", "DEPOT_OIDC_TOKEN", "DEPOT_OIDC_TOKEN_VARIABLE"} {
+				t.Setenv(name, "")
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			got, err := NewGitLabOIDCProvider(tt.variable).RetrieveToken(context.Background())
+			if err != nil || got != tt.want {
+				t.Errorf("RetrieveToken() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
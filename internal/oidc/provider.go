@@ -4,9 +4,11 @@ import (
 	"context"
 
 	"github.com/depot/depot-go/internal/oidc/actionspublic"
+	"github.com/depot/depot-go/internal/oidc/azure"
 	"github.com/depot/depot-go/internal/oidc/buildkite"
 	"github.com/depot/depot-go/internal/oidc/circleci"
 	"github.com/depot/depot-go/internal/oidc/github"
+	"github.com/depot/depot-go/internal/oidc/gitlab"
 )
 
 type OIDCProvider interface {
@@ -18,5 +20,7 @@ var Providers = []OIDCProvider{
 	github.NewGitHubOIDCProvider(),
 	circleci.NewCircleCIOIDCProvider(),
 	buildkite.NewBuildkiteOIDCProvider(),
+	gitlab.NewGitLabOIDCProvider(""),
+	azure.NewAzurePipelinesOIDCProvider(),
 	actionspublic.NewActionsPublicProvider(),
 }
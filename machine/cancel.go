@@ -0,0 +1,138 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBuildCanceling is the cause of contexts from CancelContext when the
+// Depot API is about to cancel the build.  The cause also wraps
+// context.DeadlineExceeded, as the build ran out of time.
+var ErrBuildCanceling = errors.New("build is about to be canceled by Depot")
+
+// WithCancelsAtFunc calls fn whenever the Depot API reports a new time at
+// which it will cancel the build.  This happens when the build runs longer
+// than allowed.  fn is called from the health reporting goroutine.
+func WithCancelsAtFunc(fn func(cancelsAt time.Time)) Option {
+	return func(m *Machine) {
+		m.onCancelsAt = fn
+	}
+}
+
+// CancelsAt returns when the Depot API will cancel the build.  The boolean is
+// false if the API has not reported a deadline.
+func (m *Machine) CancelsAt() (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cancelsAt, !m.cancelsAt.IsZero()
+}
+
+// CancelContext returns a context that is canceled margin before the Depot API
+// will cancel the build, giving the caller time to finish gracefully.  Its
+// deadline follows every new cancels_at reported by the API, so like any
+// context past its deadline its Err is context.DeadlineExceeded and its
+// cause, see context.Cause, wraps ErrBuildCanceling.
+func (m *Machine) CancelContext(ctx context.Context, margin time.Duration) (context.Context, context.CancelFunc) {
+	parent, cancel := context.WithCancel(ctx)
+	c := &cancelContext{done: make(chan struct{})}
+
+	cancelsAt, changed := m.cancelsAtState()
+	c.derive(parent, cancelsAt, margin)
+
+	go func() {
+		for {
+			select {
+			case <-c.current().Done():
+				close(c.done)
+				return
+			case <-changed:
+				cancelsAt, changed = m.cancelsAtState()
+				c.derive(parent, cancelsAt, margin)
+			}
+		}
+	}()
+
+	return c, cancel
+}
+
+// cancelContext is a context whose deadline can move.  It is re-derived from
+// its parent with the new deadline whenever cancels_at changes, and done once
+// the current derivation is done.
+type cancelContext struct {
+	done chan struct{}
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *cancelContext) derive(parent context.Context, cancelsAt time.Time, margin time.Duration) {
+	ctx, cancel := context.WithCancel(parent)
+	if !cancelsAt.IsZero() {
+		cause := fmt.Errorf("%w at %s: %w", ErrBuildCanceling, cancelsAt.Format(time.RFC3339), context.DeadlineExceeded)
+		ctx, cancel = context.WithDeadlineCause(parent, cancelsAt.Add(-margin), cause)
+	}
+
+	c.mu.Lock()
+	prev := c.cancel
+	c.ctx, c.cancel = ctx, cancel
+	c.mu.Unlock()
+
+	if prev != nil {
+		prev()
+	}
+}
+
+func (c *cancelContext) current() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ctx
+}
+
+func (c *cancelContext) Deadline() (time.Time, bool) { return c.current().Deadline() }
+func (c *cancelContext) Done() <-chan struct{}       { return c.done }
+func (c *cancelContext) Value(key any) any           { return c.current().Value(key) }
+
+func (c *cancelContext) Err() error {
+	select {
+	case <-c.done:
+		return c.current().Err()
+	default:
+		return nil
+	}
+}
+
+func (m *Machine) setCancelsAt(cancelsAt time.Time) {
+	m.mu.Lock()
+	if cancelsAt.Equal(m.cancelsAt) {
+		m.mu.Unlock()
+		return
+	}
+	m.cancelsAt = cancelsAt
+	if m.cancelsAtChanged != nil {
+		close(m.cancelsAtChanged)
+		m.cancelsAtChanged = nil
+	}
+	onCancelsAt := m.onCancelsAt
+	m.mu.Unlock()
+
+	if onCancelsAt != nil {
+		onCancelsAt(cancelsAt)
+	}
+}
+
+// cancelsAtState returns the current deadline and a channel closed when it changes.
+func (m *Machine) cancelsAtState() (time.Time, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancelsAtChanged == nil {
+		m.cancelsAtChanged = make(chan struct{})
+	}
+	return m.cancelsAt, m.cancelsAtChanged
+}
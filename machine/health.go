@@ -0,0 +1,138 @@
+package machine
+
+import (
+	"context"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/api"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
+)
+
+const (
+	// healthInterval is how often health is reported while the API is reachable.
+	healthInterval = 5 * time.Second
+	// healthRetryInterval is the first retry delay after a failed report.
+	// It doubles with each consecutive failure up to healthInterval.
+	healthRetryInterval = 500 * time.Millisecond
+)
+
+// Health is the state of the machine's health reporting to the Depot API.
+type Health struct {
+	LastSuccess time.Time
+	LastFailure time.Time
+	// LastError is the error of the most recent failed report.
+	LastError error
+	// ConsecutiveFailures is the number of failed reports since the last success.
+	ConsecutiveFailures int
+	// FailingSince is the time of the first of the consecutive failures.
+	FailingSince time.Time
+}
+
+// UnreachableFor returns how long health reports have been failing, or zero
+// if the last report succeeded.
+func (h Health) UnreachableFor() time.Duration {
+	if h.ConsecutiveFailures == 0 {
+		return 0
+	}
+	return time.Since(h.FailingSince)
+}
+
+// Health returns the current health reporting state.
+func (m *Machine) Health() Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.health
+}
+
+// ReportHealth reports health to the Depot API until the machine is released
+// or the context used to acquire it is canceled.  Acquire already runs this
+// in the background.
+func (m *Machine) ReportHealth() error {
+	builderPlatform, err := ParsePlatform(m.Platform)
+	if err != nil {
+		return err
+	}
+
+	ctx := m.healthContext()
+	if m.apiClient == nil {
+		m.apiClient = api.NewClient()
+	}
+	client := m.apiClient.BuildService()
+	log := m.apiClient.Logger()
+
+	for {
+		wait := healthInterval
+		err := m.doReportHealth(ctx, client, builderPlatform)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		health := m.recordHealth(err)
+		if err != nil {
+			wait = healthBackoff(health.ConsecutiveFailures)
+			log.WarnContext(ctx, "error reporting health", "build_id", m.BuildID, "platform", m.Platform, "failures", health.ConsecutiveFailures, "error", err)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (m *Machine) doReportHealth(ctx context.Context, client cliv1connect.BuildServiceClient, builderPlatform cliv1.BuilderPlatform) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req := cliv1.ReportBuildHealthRequest{BuildId: m.BuildID, Platform: builderPlatform}
+	res, err := client.ReportBuildHealth(ctx, api.WithAuthentication(connect.NewRequest(&req), m.Token))
+	if err != nil {
+		return err
+	}
+	if res.Msg.CancelsAt != nil {
+		m.setCancelsAt(res.Msg.CancelsAt.AsTime())
+	}
+	return nil
+}
+
+// healthContext returns the context bounding health reporting.  Machines not
+// created by Acquire report until they are released.
+func (m *Machine) healthContext() context.Context {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.healthCtx == nil {
+		m.healthCtx, m.stopHealth = context.WithCancel(context.Background())
+	}
+	return m.healthCtx
+}
+
+func (m *Machine) recordHealth(err error) Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if err == nil {
+		m.health.LastSuccess = now
+		m.health.ConsecutiveFailures = 0
+	} else {
+		if m.health.ConsecutiveFailures == 0 {
+			m.health.FailingSince = now
+		}
+		m.health.LastFailure = now
+		m.health.LastError = err
+		m.health.ConsecutiveFailures++
+	}
+	return m.health
+}
+
+func healthBackoff(failures int) time.Duration {
+	wait := healthRetryInterval
+	for i := 1; i < failures && wait < healthInterval; i++ {
+		wait *= 2
+	}
+	return min(wait, healthInterval)
+}
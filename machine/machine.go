@@ -2,19 +2,23 @@ package machine
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"log"
 	"net"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"connectrpc.com/connect"
 	"github.com/depot/depot-go/api"
 	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
-	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
 	"github.com/moby/buildkit/client"
+	_ "github.com/moby/buildkit/client/connhelper/dockercontainer" // Register docker-container:// for local buildkitd.
 	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
 )
 
 type Machine struct {
@@ -27,9 +31,76 @@ type Machine struct {
 	CACert     string
 	Cert       string
 	Key        string
+	// Compressor is the gRPC compression the Depot API chose for this machine.
+	Compressor Compressor
+
+	apiClient   *api.Client
+	local       bool
+	clientOpts  []client.ClientOpt
+	client      *client.Client
+	releaseOnce sync.Once
+	releaseErr  error
+	// onRelease is called by Release, e.g. to end the context AcquireAll
+	// acquired the machine with.
+	onRelease func()
+
+	mu               sync.Mutex
+	healthCtx        context.Context
+	stopHealth       context.CancelFunc
+	health           Health
+	cancelsAt        time.Time
+	cancelsAtChanged chan struct{}
+	onCancelsAt      func(time.Time)
+}
+
+// Option configures a Machine.
+type Option func(*Machine)
+
+// WithClient uses client for all API requests of the machine.
+// Without this option a client with the default configuration is used.
+func WithClient(client *api.Client) Option {
+	return func(m *Machine) {
+		m.apiClient = client
+	}
+}
+
+// WithBuildkitHost connects to the buildkitd at addr instead of a Depot machine.
+// addr uses the same format as BUILDKIT_HOST, e.g. unix:///run/buildkit/buildkitd.sock,
+// tcp://127.0.0.1:1234 or docker-container://buildkitd.  The Depot API is not
+// contacted and no health is reported.  An empty addr leaves the machine unchanged
+// so the option can be driven directly by configuration.
+func WithBuildkitHost(addr string) Option {
+	return func(m *Machine) {
+		if addr == "" {
+			return
+		}
+		m.local = true
+		m.Addr = addr
+	}
+}
 
-	client           *client.Client
-	reportHealthDone chan struct{}
+// WithBuildkitClientOpts adds options used when creating the buildkit client,
+// e.g. client.WithCredentials for a self-hosted buildkitd using mTLS.
+func WithBuildkitClientOpts(opts ...client.ClientOpt) Option {
+	return func(m *Machine) {
+		m.clientOpts = append(m.clientOpts, opts...)
+	}
+}
+
+type Compressor int
+
+const (
+	CompressorIdentity Compressor = iota
+	CompressorGzip
+)
+
+func (c Compressor) String() string {
+	switch c {
+	case CompressorGzip:
+		return gzip.Name
+	default:
+		return "identity"
+	}
 }
 
 type EngineKind int
@@ -39,48 +110,60 @@ const (
 	EngineKindDagger
 )
 
-// Platform can be "amd64" or "arm64".
+// Platform is an architecture such as "amd64" or "arm64", or an OCI platform such as "linux/arm64".
 // This reports health continually to the Depot API and waits for the buildkit
-// machine and engine to be ready.  This can be canceled by canceling the context.
-func Acquire(ctx context.Context, buildID, token, platform string) (*Machine, error) {
-	return AcquireMachineEngine(ctx, buildID, token, platform, EngineKindBuildkit, "")
+// machine and engine to be ready.  This can be canceled by canceling the context,
+// which also stops health reporting.
+func Acquire(ctx context.Context, buildID, token, platform string, opts ...Option) (*Machine, error) {
+	return AcquireMachineEngine(ctx, buildID, token, platform, EngineKindBuildkit, "", opts...)
 }
 
-// Platform can be "amd64" or "arm64".
+// Platform is an architecture such as "amd64" or "arm64", or an OCI platform such as "linux/arm64".
 // This reports health continually to the Depot API and waits for the buildkit
-// machine and engine to be ready.  This can be canceled by canceling the context.
-func AcquireBuildkit(ctx context.Context, buildID, token, platform string) (*Machine, error) {
-	return AcquireMachineEngine(ctx, buildID, token, platform, EngineKindBuildkit, "")
+// machine and engine to be ready.  This can be canceled by canceling the context,
+// which also stops health reporting.
+func AcquireBuildkit(ctx context.Context, buildID, token, platform string, opts ...Option) (*Machine, error) {
+	return AcquireMachineEngine(ctx, buildID, token, platform, EngineKindBuildkit, "", opts...)
 }
 
-// Platform can be "amd64" or "arm64".
+// Platform is an architecture such as "amd64" or "arm64", or an OCI platform such as "linux/arm64".
 // This reports health continually to the Depot API and waits for the machine with the dagger version to be ready.
-// This can be canceled by canceling the context.
-func AcquireDagger(ctx context.Context, buildID, token, platform, engineVersion string) (*Machine, error) {
-	return AcquireMachineEngine(ctx, buildID, token, platform, EngineKindDagger, engineVersion)
+// This can be canceled by canceling the context, which also stops health reporting.
+func AcquireDagger(ctx context.Context, buildID, token, platform, engineVersion string, opts ...Option) (*Machine, error) {
+	return AcquireMachineEngine(ctx, buildID, token, platform, EngineKindDagger, engineVersion, opts...)
 }
 
-func AcquireMachineEngine(ctx context.Context, buildID, token, platform string, engineKind EngineKind, engineVersion string) (*Machine, error) {
+func AcquireMachineEngine(ctx context.Context, buildID, token, platform string, engineKind EngineKind, engineVersion string, opts ...Option) (*Machine, error) {
 	m := &Machine{
-		BuildID:          buildID,
-		Token:            token,
-		Platform:         platform,
-		reportHealthDone: make(chan struct{}),
+		BuildID:  buildID,
+		Token:    token,
+		Platform: platform,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.apiClient == nil {
+		m.apiClient = api.NewClient()
 	}
 
+	builderPlatform, err := ParsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.local {
+		return m, nil
+	}
+
+	m.healthCtx, m.stopHealth = context.WithCancel(ctx)
 	go func() {
 		err := m.ReportHealth()
 		if err != nil {
-			log.Printf("warning: failed to report health for %s machine: %v\n", m.Platform, err)
+			m.apiClient.Logger().WarnContext(ctx, "failed to report health", "build_id", m.BuildID, "platform", m.Platform, "error", err)
 		}
 	}()
 
-	builderPlatform := cliv1.BuilderPlatform_BUILDER_PLATFORM_AMD64
-	if strings.Contains(m.Platform, "arm") || strings.Contains(m.Platform, "aarch") {
-		builderPlatform = cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64
-	}
-
-	client := api.NewBuildClient()
+	client := m.apiClient.BuildService()
 	req := cliv1.GetBuildKitConnectionRequest{
 		BuildId:  m.BuildID,
 		Platform: builderPlatform,
@@ -103,6 +186,7 @@ func AcquireMachineEngine(ctx context.Context, buildID, token, platform string,
 	for {
 		resp, err := client.GetBuildKitConnection(ctx, api.WithAuthentication(connect.NewRequest(&req), m.Token))
 		if err != nil {
+			_ = m.Release()
 			return nil, err
 		}
 
@@ -113,11 +197,15 @@ func AcquireMachineEngine(ctx context.Context, buildID, token, platform string,
 			m.CACert = connection.Active.CaCert.Cert
 			m.Cert = connection.Active.Cert.Cert
 			m.Key = connection.Active.Cert.Key
+			if connection.Active.GetGzip() != nil {
+				m.Compressor = CompressorGzip
+			}
 			return m, nil
 		case *cliv1.GetBuildKitConnectionResponse_Pending:
 			select {
 			case <-time.After(time.Duration(connection.Pending.WaitMs) * time.Millisecond):
 			case <-ctx.Done():
+				_ = m.Release()
 				return nil, ctx.Err()
 			}
 			continue
@@ -125,49 +213,23 @@ func AcquireMachineEngine(ctx context.Context, buildID, token, platform string,
 	}
 }
 
-func (m *Machine) ReportHealth() error {
-	var builderPlatform cliv1.BuilderPlatform
-	switch m.Platform {
-	case "amd64":
-		builderPlatform = cliv1.BuilderPlatform_BUILDER_PLATFORM_AMD64
-	case "arm64":
-		builderPlatform = cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64
-	default:
-		return errors.Errorf("unsupported platform: %s", m.Platform)
-	}
-
-	client := api.NewBuildClient()
-	for {
-		err := m.doReportHealth(context.Background(), client, builderPlatform)
-		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				return nil
-			}
-			fmt.Printf("error reporting health: %s", err.Error())
-			client = api.NewBuildClient()
-		}
-		select {
-		case <-time.After(5 * time.Second):
-		case <-m.reportHealthDone:
-			return nil
+// Release stops reporting health and closes the buildkit client.
+// It is safe to call Release more than once.
+func (m *Machine) Release() error {
+	m.releaseOnce.Do(func() {
+		_ = m.healthContext()
+		m.mu.Lock()
+		m.stopHealth()
+		m.mu.Unlock()
+		if m.onRelease != nil {
+			m.onRelease()
 		}
-	}
-}
 
-func (m *Machine) doReportHealth(ctx context.Context, client cliv1connect.BuildServiceClient, builderPlatform cliv1.BuilderPlatform) error {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	req := cliv1.ReportBuildHealthRequest{BuildId: m.BuildID, Platform: builderPlatform}
-	_, err := client.ReportBuildHealth(ctx, api.WithAuthentication(connect.NewRequest(&req), m.Token))
-	return err
-}
-
-func (m *Machine) Release() error {
-	close(m.reportHealthDone)
-	if m.client != nil {
-		return m.client.Close()
-	}
-	return nil
+		if m.client != nil {
+			m.releaseErr = m.client.Close()
+		}
+	})
+	return m.releaseErr
 }
 
 func (m *Machine) Client(ctx context.Context) (*client.Client, error) {
@@ -175,6 +237,15 @@ func (m *Machine) Client(ctx context.Context) (*client.Client, error) {
 		return m.client, nil
 	}
 
+	if m.local {
+		c, err := client.New(ctx, m.Addr, m.clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		m.client = c
+		return c, nil
+	}
+
 	opts := []client.ClientOpt{
 		client.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
 			addr = strings.TrimPrefix(addr, "tcp://")
@@ -182,44 +253,21 @@ func (m *Machine) Client(ctx context.Context) (*client.Client, error) {
 		}),
 	}
 
-	// We create all these files as buildkit does not allow control of the gRPC client
-	// without using overly restrictive private structs.
+	// TLS material is only kept in memory so no private keys are written to disk.
 	if m.Cert != "" {
-		file, err := os.CreateTemp("", "depot-cert")
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to create temp file")
-		}
-		defer func() { _ = file.Close() }()
-		err = os.WriteFile(file.Name(), []byte(m.Cert), 0600)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to write cert to temp file")
-		}
-		cert := file.Name()
-
-		file, err = os.CreateTemp("", "depot-key")
+		tlsConfig, err := m.tlsConfig()
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create temp file")
-		}
-		defer func() { _ = file.Close() }()
-		err = os.WriteFile(file.Name(), []byte(m.Key), 0600)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to write key to temp file")
-		}
-		key := file.Name()
-
-		file, err = os.CreateTemp("", "depot-ca-cert")
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to create temp file")
+			return nil, err
 		}
-		defer func() { _ = file.Close() }()
-		err = os.WriteFile(file.Name(), []byte(m.CACert), 0600)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to write CA cert to temp file")
+		opts = append(opts, client.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))))
+		if m.ServerName != "" {
+			opts = append(opts, client.WithGRPCDialOption(grpc.WithAuthority(m.ServerName)))
 		}
-		caCert := file.Name()
-
-		opts = append(opts, client.WithCredentials(cert, key), client.WithServerConfig(m.ServerName, caCert))
 	}
+	if m.Compressor == CompressorGzip {
+		opts = append(opts, client.WithGRPCDialOption(grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name))))
+	}
+	opts = append(opts, m.clientOpts...)
 
 	c, err := client.New(ctx, m.Addr, opts...)
 	if err != nil {
@@ -230,6 +278,25 @@ func (m *Machine) Client(ctx context.Context) (*client.Client, error) {
 	return c, nil
 }
 
+func (m *Machine) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.X509KeyPair([]byte(m.Cert), []byte(m.Key))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read certificate/key")
+	}
+
+	rootCAs := x509.NewCertPool()
+	if ok := rootCAs.AppendCertsFromPEM([]byte(m.CACert)); !ok {
+		return nil, errors.New("failed to append ca certs")
+	}
+
+	return &tls.Config{
+		ServerName:   m.ServerName,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      rootCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
 func (m *Machine) CheckReady(ctx context.Context) (*client.Client, error) {
 	client, err := m.Client(ctx)
 	if err != nil {
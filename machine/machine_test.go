@@ -0,0 +1,360 @@
+package machine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/depot/depot-go/build"
+	"github.com/depot/depot-go/depottest"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/depot/depot-go/proto/depot/cli/v1/cliv1connect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestAcquireBuildkitHost(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	m, err := Acquire(context.Background(), "build", "token", "arm64",
+		WithClient(server.Client()),
+		WithBuildkitHost("unix:///run/buildkit/buildkitd.sock"),
+	)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer func() { _ = m.Release() }()
+
+	if m.Addr != "unix:///run/buildkit/buildkitd.sock" {
+		t.Errorf("Addr = %q, want the buildkit host", m.Addr)
+	}
+	if calls := server.Calls(""); len(calls) != 0 {
+		t.Errorf("Acquire() made %d API calls, want none", len(calls))
+	}
+}
+
+func TestAcquireCompressor(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	b := server.AddBuild(depottest.Build{ProjectID: "project"})
+
+	active := depottest.Active("tcp://127.0.0.1:1234")
+	active.GetActive().Compressor = &cliv1.GetBuildKitConnectionResponse_ActiveConnection_Gzip_{
+		Gzip: &cliv1.GetBuildKitConnectionResponse_ActiveConnection_Gzip{},
+	}
+	server.ScriptConnection(depottest.Active("tcp://127.0.0.1:1234"), active)
+
+	for _, want := range []Compressor{CompressorIdentity, CompressorGzip} {
+		m, err := Acquire(ctx, b.ID, b.Token, "amd64", WithClient(server.Client()))
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		_ = m.Release()
+
+		if m.Compressor != want {
+			t.Errorf("Compressor = %v, want %v", m.Compressor, want)
+		}
+	}
+}
+
+func TestCancelsAt(t *testing.T) {
+	buildkitd := depottest.NewBuildkitd()
+	defer buildkitd.Close()
+	buildkitd.BlockListWorkers(true)
+
+	server := depottest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	b := server.AddBuild(depottest.Build{ProjectID: "project"})
+	cancelsAt := time.Now().Add(time.Second).Truncate(time.Millisecond)
+	server.SetCancelsAt(b.ID, cancelsAt)
+	server.SetConnection(depottest.Active(buildkitd.Addr))
+
+	reported := make(chan time.Time, 1)
+	m, err := Acquire(ctx, b.ID, b.Token, "amd64",
+		WithClient(server.Client()),
+		WithCancelsAtFunc(func(cancelsAt time.Time) { reported <- cancelsAt }),
+	)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer func() { _ = m.Release() }()
+
+	buildCtx, cancel := m.CancelContext(ctx, 500*time.Millisecond)
+	defer cancel()
+
+	select {
+	case got := <-reported:
+		if !got.Equal(cancelsAt) {
+			t.Errorf("reported cancels_at = %v, want %v", got, cancelsAt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancels_at was not reported")
+	}
+	if got, ok := m.CancelsAt(); !ok || !got.Equal(cancelsAt) {
+		t.Errorf("CancelsAt() = %v, %v, want %v", got, ok, cancelsAt)
+	}
+
+	// A buildkit call on the context fails as timed out, not canceled.
+	c, err := m.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ListWorkers(buildCtx)
+	if time.Now().After(cancelsAt) {
+		t.Errorf("context canceled after cancels_at")
+	}
+	var timeout *build.TimeoutError
+	if classified := build.ClassifyError(err, nil); !errors.As(classified, &timeout) {
+		t.Errorf("ClassifyError(ListWorkers()) = %v, want *build.TimeoutError", classified)
+	}
+	if !errors.Is(buildCtx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Err() = %v, want context.DeadlineExceeded", buildCtx.Err())
+	}
+	if !errors.Is(context.Cause(buildCtx), ErrBuildCanceling) {
+		t.Errorf("context.Cause() = %v, want ErrBuildCanceling", context.Cause(buildCtx))
+	}
+}
+
+func TestCancelContextDeadline(t *testing.T) {
+	m := &Machine{}
+	ctx, cancel := m.CancelContext(context.Background(), 0)
+	defer cancel()
+
+	later := time.Now().Add(time.Hour)
+	m.setCancelsAt(later)
+	for deadline, _ := ctx.Deadline(); !deadline.Equal(later); deadline, _ = ctx.Deadline() {
+		time.Sleep(time.Millisecond)
+	}
+	m.setCancelsAt(time.Now().Add(50 * time.Millisecond))
+
+	select {
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			t.Errorf("Err() = %v, want context.DeadlineExceeded", ctx.Err())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("context was not canceled at the earlier deadline")
+	}
+
+	ctx, cancel = (&Machine{}).CancelContext(context.Background(), time.Hour)
+	cancel()
+	<-ctx.Done()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", ctx.Err())
+	}
+}
+
+func TestReportHealth(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := server.AddBuild(depottest.Build{ProjectID: "project"})
+	server.SetConnection(depottest.Active("tcp://127.0.0.1:1234"))
+	unavailable := connect.NewError(connect.CodeUnavailable, errors.New("down"))
+	server.FailNext(cliv1connect.BuildServiceReportBuildHealthProcedure, unavailable, unavailable)
+
+	m, err := Acquire(ctx, b.ID, b.Token, "linux/arm64", WithClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Health().LastSuccess.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	health := m.Health()
+	if health.LastSuccess.IsZero() || health.ConsecutiveFailures != 0 || health.UnreachableFor() != 0 {
+		t.Fatalf("Health() = %+v, want a success after retries", health)
+	}
+	if health.LastFailure.IsZero() || health.LastError == nil {
+		t.Errorf("Health() = %+v, want the earlier failures recorded", health)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	calls := len(server.Calls(cliv1connect.BuildServiceReportBuildHealthProcedure))
+	time.Sleep(healthRetryInterval * 2)
+	if got := len(server.Calls(cliv1connect.BuildServiceReportBuildHealthProcedure)); got != calls {
+		t.Errorf("health reported %d more times after the context was canceled", got-calls)
+	}
+
+	if err := m.Release(); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+	if err := m.Release(); err != nil {
+		t.Errorf("second Release() error = %v", err)
+	}
+}
+
+func TestClientKeepsTLSInMemory(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	ca, caKey, caPEM := newCertificate(t, "ca", nil, nil)
+	serverPEM, serverKeyPEM := newLeaf(t, "buildkitd", ca, caKey)
+	clientPEM, clientKeyPEM := newLeaf(t, "client", ca, caKey)
+
+	serverCert, err := tls.X509KeyPair(serverPEM, serverKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	buildkitd := depottest.NewBuildkitd(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	defer buildkitd.Close()
+
+	m := &Machine{
+		Addr:       buildkitd.Addr,
+		ServerName: "buildkitd",
+		CACert:     string(caPEM),
+		Cert:       string(clientPEM),
+		Key:        string(clientKeyPEM),
+		Compressor: CompressorGzip,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := m.CheckReady(ctx); err != nil {
+		t.Fatalf("CheckReady() error = %v", err)
+	}
+	if err := m.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("unexpected file left behind: %s", entry.Name())
+	}
+}
+
+func TestClientCompression(t *testing.T) {
+	for _, compressor := range []Compressor{CompressorIdentity, CompressorGzip} {
+		t.Run(compressor.String(), func(t *testing.T) {
+			buildkitd := depottest.NewBuildkitd()
+			defer buildkitd.Close()
+
+			m := &Machine{Addr: buildkitd.Addr, Compressor: compressor}
+			defer func() { _ = m.Release() }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := m.CheckReady(ctx); err != nil {
+				t.Fatalf("CheckReady() error = %v", err)
+			}
+
+			want := ""
+			if compressor == CompressorGzip {
+				want = "gzip"
+			}
+			if got := buildkitd.Encodings(); len(got) != 1 || got[0] != want {
+				t.Errorf("grpc-encoding = %q, want [%q]", got, want)
+			}
+		})
+	}
+}
+
+func newCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newLeaf(t *testing.T, name string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte) {
+	t.Helper()
+
+	_, key, certPEM := newCertificate(t, name, ca, caKey)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestAcquireAll(t *testing.T) {
+	server := depottest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	b := server.AddBuild(depottest.Build{ProjectID: "project"})
+	server.SetConnection(depottest.Active("tcp://127.0.0.1:1234"))
+
+	set, err := AcquireAll(ctx, b.ID, b.Token, []string{"arm64", "amd64", "linux/arm64", "aarch64", "linux/amd64/v2"}, WithClient(server.Client()))
+	if err != nil {
+		t.Fatalf("AcquireAll() error = %v", err)
+	}
+	if got := set.Platforms(); len(got) != 2 || got[0] != "linux/amd64" || got[1] != "linux/arm64" {
+		t.Errorf("Platforms() = %v, want [linux/amd64 linux/arm64]", got)
+	}
+	if err := set.Release(); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+	calls := len(server.Calls(cliv1connect.BuildServiceGetBuildKitConnectionProcedure))
+	if calls != 2 {
+		t.Errorf("acquired %d machines, want one per platform", calls)
+	}
+
+	if _, err := AcquireAll(ctx, b.ID, b.Token, []string{"amd64", "linux/arm/v7"}, WithClient(server.Client())); !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Errorf("AcquireAll() error = %v, want ErrUnsupportedPlatform", err)
+	}
+	if got := len(server.Calls(cliv1connect.BuildServiceGetBuildKitConnectionProcedure)); got != calls {
+		t.Errorf("acquired %d machines for an unsupported platform, want none", got-calls)
+	}
+
+	server.SetError(cliv1connect.BuildServiceGetBuildKitConnectionProcedure, connect.NewError(connect.CodeResourceExhausted, errors.New("no capacity")))
+	if _, err := AcquireAll(ctx, b.ID, b.Token, []string{"amd64", "arm64"}, WithClient(server.Client())); connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Errorf("AcquireAll() error = %v, want resource exhausted", err)
+	}
+}
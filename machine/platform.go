@@ -0,0 +1,59 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+// ErrUnsupportedPlatform is returned for platforms Depot has no builders for.
+var ErrUnsupportedPlatform = errors.New("unsupported platform")
+
+// ParsePlatform returns the Depot builder platform for an architecture such
+// as "arm64" or "aarch64", or an OCI platform such as "linux/arm64/v8".
+// Depot builders support linux/amd64 and linux/arm64.
+func ParsePlatform(platform string) (cliv1.BuilderPlatform, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(platform)), "/")
+
+	var arch, variant string
+	switch len(parts) {
+	case 1:
+		arch = parts[0]
+	case 2, 3:
+		if parts[0] != "linux" {
+			return cliv1.BuilderPlatform_BUILDER_PLATFORM_UNSPECIFIED, unsupportedPlatform(platform)
+		}
+		arch = parts[1]
+		if len(parts) == 3 {
+			variant = parts[2]
+		}
+	default:
+		return cliv1.BuilderPlatform_BUILDER_PLATFORM_UNSPECIFIED, unsupportedPlatform(platform)
+	}
+
+	switch arch {
+	case "amd64", "x86_64", "x86-64":
+		if variant == "" || variant == "v1" || variant == "v2" || variant == "v3" {
+			return cliv1.BuilderPlatform_BUILDER_PLATFORM_AMD64, nil
+		}
+	case "arm64", "aarch64":
+		if variant == "" || variant == "v8" {
+			return cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64, nil
+		}
+	}
+	return cliv1.BuilderPlatform_BUILDER_PLATFORM_UNSPECIFIED, unsupportedPlatform(platform)
+}
+
+func unsupportedPlatform(platform string) error {
+	return fmt.Errorf("%w %q: Depot builders support linux/amd64 and linux/arm64", ErrUnsupportedPlatform, platform)
+}
+
+// ociPlatform returns the OCI platform of a Depot builder platform, e.g. "linux/arm64".
+func ociPlatform(platform cliv1.BuilderPlatform) string {
+	if platform == cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64 {
+		return "linux/arm64"
+	}
+	return "linux/amd64"
+}
@@ -0,0 +1,48 @@
+package machine
+
+import (
+	"errors"
+	"testing"
+
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		platform string
+		want     cliv1.BuilderPlatform
+		wantErr  bool
+	}{
+		{platform: "amd64", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_AMD64},
+		{platform: "x86_64", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_AMD64},
+		{platform: "linux/amd64", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_AMD64},
+		{platform: "linux/amd64/v3", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_AMD64},
+		{platform: "arm64", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64},
+		{platform: "aarch64", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64},
+		{platform: "linux/arm64", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64},
+		{platform: "linux/arm64/v8", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64},
+		{platform: "Linux/ARM64", want: cliv1.BuilderPlatform_BUILDER_PLATFORM_ARM64},
+		{platform: "", wantErr: true},
+		{platform: "linux/arm/v7", wantErr: true},
+		{platform: "windows/amd64", wantErr: true},
+		{platform: "linux/riscv64", wantErr: true},
+		{platform: "linux/arm64/v8/extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			got, err := ParsePlatform(tt.platform)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedPlatform) {
+					t.Errorf("ParsePlatform() error = %v, want ErrUnsupportedPlatform", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePlatform() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePlatform() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
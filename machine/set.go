@@ -0,0 +1,130 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/moby/buildkit/client"
+	"golang.org/x/sync/errgroup"
+)
+
+// Set holds the machines of a multi-platform build keyed by OCI platform,
+// e.g. "linux/arm64".
+type Set map[string]*Machine
+
+// AcquireAll acquires a buildkit machine for each platform concurrently.
+// Platforms are parsed with ParsePlatform, so "arm64" and "linux/arm64" share
+// one machine.  If any platform is unsupported nothing is acquired.  If any
+// machine cannot be acquired the others are released and the error is
+// returned.  Health is reported for each machine until the set is released.
+func AcquireAll(ctx context.Context, buildID, token string, platforms []string, opts ...Option) (Set, error) {
+	var unique []string
+	for _, platform := range platforms {
+		builderPlatform, err := ParsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+		if oci := ociPlatform(builderPlatform); !slices.Contains(unique, oci) {
+			unique = append(unique, oci)
+		}
+	}
+
+	var (
+		mu  sync.Mutex
+		set = Set{}
+	)
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	for _, platform := range unique {
+		eg.Go(func() error {
+			// Health reporting is bound to ctx rather than egCtx so it outlives AcquireAll.
+			m, err := acquireWithin(ctx, egCtx, buildID, token, platform, opts...)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			set[platform] = m
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		_ = set.Release()
+		return nil, err
+	}
+	return set, nil
+}
+
+// acquireWithin acquires a machine whose lifetime is bound to ctx while the
+// wait for it to become ready is canceled when waitCtx is.
+func acquireWithin(ctx, waitCtx context.Context, buildID, token, platform string, opts ...Option) (*Machine, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(waitCtx, cancel)
+
+	m, err := Acquire(ctx, buildID, token, platform, opts...)
+	if !stop() {
+		if m != nil {
+			_ = m.Release()
+		}
+		return nil, waitCtx.Err()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	m.onRelease = cancel
+	return m, nil
+}
+
+// Platforms returns the platforms of the set in sorted order.
+func (s Set) Platforms() []string {
+	platforms := make([]string, 0, len(s))
+	for platform := range s {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// Connect waits for every machine to accept connections and returns the
+// buildkit clients keyed by platform.
+func (s Set) Connect(ctx context.Context) (map[string]*client.Client, error) {
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client.Client, len(s))
+	)
+
+	eg, ctx := errgroup.WithContext(ctx)
+	for platform, m := range s {
+		eg.Go(func() error {
+			c, err := m.Connect(ctx)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			clients[platform] = c
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// Release releases every machine in the set.
+func (s Set) Release() error {
+	var errs []error
+	for _, m := range s {
+		if m != nil {
+			errs = append(errs, m.Release())
+		}
+	}
+	return errors.Join(errs...)
+}
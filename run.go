@@ -0,0 +1,152 @@
+package depot
+
+import (
+	"context"
+	"time"
+
+	"github.com/depot/depot-go/build"
+	"github.com/depot/depot-go/machine"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/moby/buildkit/client"
+)
+
+const (
+	defaultConnectTimeout = 5 * time.Minute
+	reportTimeout         = 30 * time.Second
+)
+
+// timingsTimeout bounds how long Run waits for the channels tapped by Timings
+// to be closed.  A variable for tests.
+var timingsTimeout = 10 * time.Second
+
+// RunOptions configures Run.
+type RunOptions struct {
+	// Client is used for all API requests.  Defaults to NewClient().
+	Client *Client
+	// Token authenticates the build.  Defaults to the client's token source.
+	Token string
+	// ProjectID is the Depot project to build in.  Ignored if Request is set.
+	ProjectID string
+	// Request registers the build.  Defaults to a request for ProjectID
+	// describing Dockerfiles.
+	Request *cliv1.CreateBuildRequest
+	// Dockerfiles are the Dockerfile builds fn runs, one per target of a
+	// bake-style build.  Their Dockerfiles are reported to Depot.
+	Dockerfiles []build.DockerfileBuild
+	// NoReportBuildContext disables reporting the Dockerfiles, e.g. for
+	// sensitive repositories.
+	NoReportBuildContext bool
+	// Platform of the machine to acquire, e.g. "amd64" or "linux/arm64".  Defaults to "amd64".
+	Platform string
+	// ConnectTimeout bounds how long to wait for buildkitd to accept connections.
+	// Defaults to five minutes.
+	ConnectTimeout time.Duration
+	// MachineOptions are passed to machine.Acquire.
+	MachineOptions []machine.Option
+	// Timings, if set, are reported to Depot when fn returns.  fn passes
+	// Timings.Tap of its status channel to Solve.
+	Timings *build.Timings
+	// Profiler, if set, profiles this process while fn runs and uploads the
+	// profiles with it when Depot returns a profiler token for the build.
+	Profiler build.Uploader
+}
+
+// Run registers a build, acquires a machine, connects to its buildkitd and
+// calls fn with the buildkit client.  The result of fn is reported to Depot
+// as the result of the build: success, failure, or canceled when ctx is
+// canceled.  The machine is always released.  The error of fn, or of any
+// step before it, is returned classified by build.ClassifyError.
+func Run(ctx context.Context, opts RunOptions, fn func(ctx context.Context, c *client.Client) error) (err error) {
+	// Errors before the build is registered are classified here, later ones
+	// before they are reported by Finish.
+	defer func() { err = build.ClassifyError(err, opts.Timings) }()
+
+	c := opts.Client
+	if c == nil {
+		c = NewClient()
+	}
+
+	req := opts.Request
+	if req == nil {
+		req = &cliv1.CreateBuildRequest{ProjectId: opts.ProjectID}
+		for _, d := range opts.Dockerfiles {
+			buildOpts, err := d.BuildOptions()
+			if err != nil {
+				return err
+			}
+			req.Options = append(req.Options, buildOpts)
+		}
+	}
+
+	b, err := build.NewBuild(ctx, req, opts.Token, build.WithClient(c))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = build.ClassifyError(err, opts.Timings)
+		if finishErr := b.Finish(ctx, err); finishErr != nil {
+			c.Logger().ErrorContext(ctx, "error releasing builder", "build_id", b.ID, "error", finishErr)
+		}
+	}()
+
+	if len(opts.Dockerfiles) > 0 && !opts.NoReportBuildContext {
+		if reportErr := b.ReportBuildContext(ctx, opts.Dockerfiles...); reportErr != nil {
+			c.Logger().WarnContext(ctx, "error reporting build context", "build_id", b.ID, "error", reportErr)
+		}
+	}
+
+	platform := opts.Platform
+	if platform == "" {
+		platform = "amd64"
+	}
+	machineOpts := append([]machine.Option{machine.WithClient(c)}, opts.MachineOptions...)
+	m, err := machine.Acquire(ctx, b.ID, b.Token, platform, machineOpts...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = m.Release() }()
+
+	connectTimeout := opts.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	connectCtx, cancelConnect := context.WithTimeout(ctx, connectTimeout)
+	defer cancelConnect()
+
+	buildkitClient, err := m.Connect(connectCtx)
+	if err != nil {
+		return err
+	}
+
+	var profiler *build.Profiler
+	if opts.Profiler != nil && b.ProfilerToken != "" {
+		profiler, err = b.StartProfiler(opts.Profiler, opts.Timings)
+		if err != nil {
+			c.Logger().WarnContext(ctx, "error starting profiler", "build_id", b.ID, "error", err)
+		}
+	}
+
+	err = fn(ctx, buildkitClient)
+	if opts.Timings != nil {
+		waitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timingsTimeout)
+		defer cancel()
+		if waitErr := opts.Timings.Wait(waitCtx); waitErr != nil {
+			c.Logger().WarnContext(ctx, "build status still open, reporting the timings recorded so far", "build_id", b.ID, "error", waitErr)
+		}
+	}
+	if profiler != nil {
+		uploadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reportTimeout)
+		defer cancel()
+		if uploadErr := profiler.Stop(uploadCtx); uploadErr != nil {
+			c.Logger().WarnContext(ctx, "error uploading profiles", "build_id", b.ID, "error", uploadErr)
+		}
+	}
+	if opts.Timings != nil {
+		reportCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reportTimeout)
+		defer cancel()
+		if reportErr := b.ReportTimings(reportCtx, opts.Timings.Steps()); reportErr != nil {
+			c.Logger().WarnContext(ctx, "error reporting timings", "build_id", b.ID, "error", reportErr)
+		}
+	}
+	return err
+}
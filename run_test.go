@@ -0,0 +1,100 @@
+package depot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/depot/depot-go/build"
+	"github.com/depot/depot-go/depottest"
+	cliv1 "github.com/depot/depot-go/proto/depot/cli/v1"
+	"github.com/moby/buildkit/client"
+)
+
+func TestRun(t *testing.T) {
+	defer func(d time.Duration) { timingsTimeout = d }(timingsTimeout)
+	timingsTimeout = 10 * time.Millisecond
+
+	buildkitd := depottest.NewBuildkitd()
+	defer buildkitd.Close()
+
+	server := depottest.NewServer()
+	defer server.Close()
+	server.SetConnection(depottest.Active(buildkitd.Addr))
+
+	errBuild := errors.New("build failed")
+	tests := []struct {
+		name       string
+		fn         func(ctx context.Context, cancel context.CancelFunc, timings *build.Timings) error
+		wantErr    error
+		wantStatus cliv1.BuildStatus
+	}{
+		{
+			name:       "success",
+			fn:         func(ctx context.Context, cancel context.CancelFunc, timings *build.Timings) error { return nil },
+			wantStatus: cliv1.BuildStatus_BUILD_STATUS_FINISHED,
+		},
+		{
+			name:       "error",
+			fn:         func(ctx context.Context, cancel context.CancelFunc, timings *build.Timings) error { return errBuild },
+			wantErr:    errBuild,
+			wantStatus: cliv1.BuildStatus_BUILD_STATUS_FAILED,
+		},
+		{
+			name: "canceled",
+			fn: func(ctx context.Context, cancel context.CancelFunc, timings *build.Timings) error {
+				cancel()
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantErr:    context.Canceled,
+			wantStatus: cliv1.BuildStatus_BUILD_STATUS_CANCELED,
+		},
+		{
+			// e.g. SolveOpt failed: the tapped channel is never closed.
+			name: "error before solve",
+			fn: func(ctx context.Context, cancel context.CancelFunc, timings *build.Timings) error {
+				timings.Tap(nil)
+				return errBuild
+			},
+			wantErr:    errBuild,
+			wantStatus: cliv1.BuildStatus_BUILD_STATUS_FAILED,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var buildID string
+			timings := build.NewTimings()
+			opts := RunOptions{Client: server.Client(), ProjectID: "project", Timings: timings}
+			err := Run(ctx, opts, func(ctx context.Context, c *client.Client) error {
+				builds := server.Builds()
+				buildID = builds[len(builds)-1].ID
+				return tt.fn(ctx, cancel, timings)
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+
+			b, _ := server.Build(buildID)
+			if b.Status != tt.wantStatus {
+				t.Errorf("build status = %v, want %v", b.Status, tt.wantStatus)
+			}
+		})
+	}
+
+	// Errors before the build is registered are classified too.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Run(ctx, RunOptions{Client: server.Client(), ProjectID: "project"}, func(ctx context.Context, c *client.Client) error {
+		t.Error("fn called for a build that was not registered")
+		return nil
+	})
+	var canceled *build.CanceledError
+	if !errors.As(err, &canceled) {
+		t.Errorf("Run() error = %v, want *build.CanceledError", err)
+	}
+}